@@ -1,10 +1,16 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
 
+	"clickhouse-integration/internal/auth"
+	"clickhouse-integration/internal/config"
 	"clickhouse-integration/internal/handlers"
+	"clickhouse-integration/internal/logging"
 	"clickhouse-integration/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -14,27 +20,77 @@ import (
 
 func main() {
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	envErr := godotenv.Load()
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal(slog.Default(), "failed to load configuration", err)
+	}
+
+	logger, err := logging.New(cfg.Log, os.Stdout)
+	if err != nil {
+		fatal(slog.Default(), "failed to initialize logging", err)
+	}
+	slog.SetDefault(logger)
+	if envErr != nil {
+		logger.Debug("no .env file found")
 	}
 
 	// Initialize services
-	clickHouseService := services.NewClickHouseService()
-	fileService := services.NewFileService("uploads")
+	profileKey := cfg.Profiles.SecretKey
+	if profileKey == "" {
+		keyPath := cfg.StatePath("profile.key")
+		key, err := services.LoadOrCreateKey(keyPath)
+		if err != nil {
+			fatal(logger, "failed to load profile key", err)
+		}
+		logger.Warn("no profile secret key configured, using generated key", "path", keyPath)
+		profileKey = key
+	}
+	profileStore, err := services.NewProfileStore(cfg.StatePath("profiles.json"), profileKey)
+	if err != nil {
+		fatal(logger, "failed to open profile store", err)
+	}
+
+	watermarkStore := services.NewWatermarkStore(cfg.StatePath("watermarks.json"), logger)
+	clickHouseService := services.NewClickHouseService(cfg.ClickHouse, watermarkStore, profileStore, logger)
+	fileService := services.NewFileService(cfg.Storage, logger)
+	sourceService := services.NewSourceService(cfg.Sources, fileService)
+	go fileService.RunJanitor(context.Background(), cfg.Storage.JanitorInterval)
+
+	authenticator, err := auth.NewAuthenticator(cfg.Auth)
+	if err != nil {
+		fatal(logger, "failed to initialize authentication", err)
+	}
+	if !authenticator.Enabled() {
+		logger.Warn("authentication is disabled; every client can use the API")
+	}
 
 	// Initialize handlers
-	clickHouseHandler := handlers.NewClickHouseHandler(clickHouseService)
-	fileHandler := handlers.NewFileHandler(fileService, clickHouseService)
+	clickHouseHandler := handlers.NewClickHouseHandler(clickHouseService, fileService, sourceService, logger)
+	fileHandler := handlers.NewFileHandler(fileService, sourceService, clickHouseService, cfg.Storage.MaxUploadSize, logger)
+	profileHandler := handlers.NewProfileHandler(profileStore)
 
-	// Initialize router
-	router := gin.Default()
+	// Initialize router. Requests are logged by the logging middleware
+	// rather than gin's plain-text logger
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	router.Use(gin.Recovery(), logging.Middleware(logger))
 
-	// Configure CORS
+	// Configure CORS. Credentials are only allowed for explicit origins;
+	// with "*" any site could otherwise make authenticated calls
+	allowAnyOrigin := len(cfg.Server.CORSOrigins) == 1 && cfg.Server.CORSOrigins[0] == "*"
 	corsConfig := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		AllowCredentials: true,
+		AllowedOrigins:   cfg.Server.CORSOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "Upload-Offset", "X-Chunk-SHA256", logging.RequestIDHeader},
+		ExposedHeaders:   []string{"Upload-Offset", logging.RequestIDHeader},
+		AllowCredentials: !allowAnyOrigin,
 	})
 
 	// Apply CORS middleware
@@ -48,34 +104,67 @@ func main() {
 	})
 
 	// API routes
-	api := router.Group("/api")
+	api := router.Group("/api", authenticator.Middleware())
 	{
 		// ClickHouse routes
 		api.POST("/clickhouse/connect", clickHouseHandler.Connect)
+		api.GET("/clickhouse/databases", clickHouseHandler.GetDatabases)
 		api.GET("/clickhouse/tables", clickHouseHandler.GetTables)
 		api.GET("/clickhouse/columns/:table", clickHouseHandler.GetColumns)
+		api.POST("/clickhouse/stats/:table", clickHouseHandler.GetTableStats)
 		api.POST("/clickhouse/export", clickHouseHandler.ExportData)
+		api.POST("/clickhouse/preview", clickHouseHandler.Preview)
 		api.POST("/clickhouse/import", clickHouseHandler.ImportData)
+		api.POST("/clickhouse/import/raw", clickHouseHandler.ImportRaw)
+		api.POST("/clickhouse/copy", clickHouseHandler.CopyTable)
+		api.POST("/clickhouse/ingest", clickHouseHandler.Ingest)
+		api.GET("/clickhouse/ingest/:id", clickHouseHandler.IngestStatus)
+
+		// Connection profile routes
+		api.GET("/profiles", profileHandler.List)
+		api.POST("/profiles", auth.RequireAdmin(), profileHandler.Create)
+		api.GET("/profiles/:name", profileHandler.Get)
+		api.PUT("/profiles/:name", auth.RequireAdmin(), profileHandler.Update)
+		api.DELETE("/profiles/:name", auth.RequireAdmin(), profileHandler.Delete)
+
+		// Admin routes
+		api.GET("/admin/files", auth.RequireAdmin(), fileHandler.ListStoredFiles)
 
 		// File routes
 		fileGroup := api.Group("/file")
 		{
-			fileGroup.POST("/upload", fileHandler.UploadFile)
-			fileGroup.GET("/columns", fileHandler.GetColumns)
-			fileGroup.GET("/preview", fileHandler.GetPreview)
-			fileGroup.POST("/import", fileHandler.ImportFile)
-			fileGroup.POST("/cleanup", fileHandler.Cleanup)
+			requireImport := auth.RequireDirection(auth.DirectionImport)
+			fileGroup.POST("/upload", requireImport, fileHandler.UploadFile)
+			fileGroup.GET("/columns", requireImport, fileHandler.GetColumns)
+			fileGroup.GET("/preview", requireImport, fileHandler.GetPreview)
+			fileGroup.GET("/profile", requireImport, fileHandler.GetProfile)
+			fileGroup.POST("/import", requireImport, fileHandler.ImportFile)
+			fileGroup.POST("/cleanup", requireImport, fileHandler.Cleanup)
+			fileGroup.POST("/uploads", requireImport, fileHandler.InitUpload)
+			fileGroup.GET("/uploads/:id", requireImport, fileHandler.GetUploadSession)
+			fileGroup.PATCH("/uploads/:id", requireImport, fileHandler.AppendChunk)
+			fileGroup.POST("/uploads/:id/complete", requireImport, fileHandler.CompleteUpload)
+			fileGroup.DELETE("/uploads/:id", requireImport, fileHandler.AbortUpload)
+			fileGroup.GET("/download/:id", auth.RequireDirection(auth.DirectionExport), fileHandler.Download)
 		}
 	}
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	server := &http.Server{
+		Addr:              cfg.Server.ListenAddr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	logger.Info("server starting", "addr", cfg.Server.ListenAddr)
+	if err := server.ListenAndServe(); err != nil {
+		fatal(logger, "failed to start server", err)
 	}
 }
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
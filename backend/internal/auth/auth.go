@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DirectionImport = "import"
+	DirectionExport = "export"
+
+	principalKey = "auth_principal"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("access denied")
+)
+
+// Principal is an authenticated caller and the roles it holds.
+type Principal struct {
+	Name  string
+	Roles []string
+
+	roles []config.RoleConfig
+}
+
+// Access describes what a request is about to do, so it can be checked
+// against the caller's roles. An empty Profile means the request carries an
+// inline connection config. ServerDir and S3Endpoint name the configured
+// source a request reads from or, for S3 exports, writes to.
+type Access struct {
+	Direction   string
+	Profile     string
+	Databases   []string
+	CustomQuery bool
+	ServerDir   string
+	S3Endpoint  string
+}
+
+// Authenticator validates API keys and JWTs. A nil or disabled
+// Authenticator lets every request through, which keeps local development
+// working without any auth configuration.
+type Authenticator struct {
+	enabled bool
+	keys    map[string]config.APIKeyConfig
+	roles   map[string]config.RoleConfig
+	jwt     *jwtVerifier
+}
+
+func NewAuthenticator(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		enabled: cfg.Enabled,
+		keys:    make(map[string]config.APIKeyConfig),
+		roles:   cfg.Roles,
+	}
+	if !cfg.Enabled {
+		return a, nil
+	}
+
+	for _, key := range cfg.APIKeys {
+		a.keys[strings.ToLower(key.KeySHA256)] = key
+	}
+	if cfg.JWT.JWKSFile != "" {
+		verifier, err := newJWTVerifier(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+	return a, nil
+}
+
+func (a *Authenticator) Enabled() bool {
+	return a != nil && a.enabled
+}
+
+// Middleware authenticates every request from either the X-API-Key header
+// or an Authorization bearer token and stores the principal in the context.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		principal, err := a.authenticate(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.Response{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.authenticateKey(key)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if a.jwt == nil {
+		// Bearer tokens are accepted as API keys when no JWKS is configured
+		return a.authenticateKey(token)
+	}
+	if strings.Count(token, ".") != 2 {
+		return a.authenticateKey(token)
+	}
+
+	name, roles, err := a.jwt.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return a.principal(name, roles), nil
+}
+
+func (a *Authenticator) authenticateKey(key string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+
+	// Compare against every key so the time taken does not reveal a match
+	var found *config.APIKeyConfig
+	for stored, cfg := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			cfg := cfg
+			found = &cfg
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+	}
+	return a.principal(found.Name, found.Roles), nil
+}
+
+// principal keeps only the roles defined in the config; unknown roles in a
+// token grant nothing.
+func (a *Authenticator) principal(name string, roles []string) *Principal {
+	p := &Principal{Name: name}
+	for _, role := range roles {
+		if cfg, ok := a.roles[role]; ok {
+			p.Roles = append(p.Roles, role)
+			p.roles = append(p.roles, cfg)
+		}
+	}
+	return p
+}
+
+// FromContext returns the authenticated principal, or nil when auth is
+// disabled.
+func FromContext(c *gin.Context) *Principal {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	principal, _ := value.(*Principal)
+	return principal
+}
+
+// Authorize checks access for the request's principal. Requests without a
+// principal are allowed, since the middleware only lets them through when
+// auth is disabled.
+func Authorize(c *gin.Context, access Access) error {
+	principal := FromContext(c)
+	if principal == nil {
+		return nil
+	}
+	return principal.Authorize(access)
+}
+
+// Authorize succeeds when a single role allows the whole access; grants
+// are not combined across roles.
+func (p *Principal) Authorize(access Access) error {
+	for _, role := range p.roles {
+		if roleAllows(role, access) {
+			return nil
+		}
+	}
+
+	target := "inline connection"
+	if access.Profile != "" {
+		target = "profile " + access.Profile
+	}
+	resources := append([]string(nil), access.Databases...)
+	if access.ServerDir != "" {
+		resources = append(resources, "server dir "+access.ServerDir)
+	}
+	if access.S3Endpoint != "" {
+		resources = append(resources, "s3 endpoint "+access.S3Endpoint)
+	}
+	return fmt.Errorf("%w: %s may not %s via %s on %s", ErrForbidden,
+		p.Name, access.Direction, target, strings.Join(resources, ", "))
+}
+
+// IsAdmin reports whether the principal holds an admin role.
+func (p *Principal) IsAdmin() bool {
+	for _, role := range p.roles {
+		if role.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+func roleAllows(role config.RoleConfig, access Access) bool {
+	if role.Admin {
+		return true
+	}
+	if !matches(role.Directions, access.Direction) {
+		return false
+	}
+	if access.Profile == "" {
+		if !role.InlineConnections {
+			return false
+		}
+	} else if !matches(role.Profiles, access.Profile) {
+		return false
+	}
+	for _, database := range access.Databases {
+		if !matches(role.Databases, database) {
+			return false
+		}
+	}
+	// A custom query can read any database, so it needs unrestricted access
+	if access.CustomQuery && !matches(role.Databases, "*") {
+		return false
+	}
+	if access.ServerDir != "" && !matches(role.ServerDirs, access.ServerDir) {
+		return false
+	}
+	if access.S3Endpoint != "" && !matches(role.S3, access.S3Endpoint) {
+		return false
+	}
+	return true
+}
+
+func matches(allowed []string, value string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireDirection rejects requests whose principal has no role allowing
+// the direction at all. Routes that do not reach ClickHouse, such as file
+// uploads and downloads, use it in place of a full Authorize check.
+func RequireDirection(direction string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := FromContext(c)
+		if principal == nil {
+			c.Next()
+			return
+		}
+		for _, role := range principal.roles {
+			if role.Admin || matches(role.Directions, direction) {
+				c.Next()
+				return
+			}
+		}
+		abortForbidden(c, fmt.Errorf("%w: %s may not %s", ErrForbidden, principal.Name, direction))
+	}
+}
+
+// RequireAdmin limits a route to principals holding an admin role.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := FromContext(c)
+		if principal == nil || principal.IsAdmin() {
+			c.Next()
+			return
+		}
+		abortForbidden(c, fmt.Errorf("%w: %s is not an admin", ErrForbidden, principal.Name))
+	}
+}
+
+func abortForbidden(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusForbidden, models.Response{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
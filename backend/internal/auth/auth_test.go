@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clickhouse-integration/internal/config"
+)
+
+var testRoles = map[string]config.RoleConfig{
+	"admin": {Admin: true},
+	"analyst": {
+		Directions: []string{DirectionExport},
+		Profiles:   []string{"warehouse"},
+		Databases:  []string{"sales", "marketing"},
+		S3:         []string{"archive"},
+	},
+	"loader": {
+		Directions:        []string{DirectionImport},
+		Profiles:          []string{"*"},
+		Databases:         []string{"staging"},
+		InlineConnections: true,
+		ServerDirs:        []string{"incoming"},
+	},
+	"explorer": {
+		Directions: []string{DirectionExport},
+		Profiles:   []string{"warehouse"},
+		Databases:  []string{"*"},
+	},
+}
+
+func principalWith(t *testing.T, roles ...string) *Principal {
+	t.Helper()
+	a, err := NewAuthenticator(config.AuthConfig{Roles: testRoles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.principal("alice", roles)
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []string
+		access  Access
+		allowed bool
+	}{
+		{"admin", []string{"admin"}, Access{Direction: DirectionImport, Databases: []string{"x"}, CustomQuery: true}, true},
+		{"granted export", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"sales"}}, true},
+		{"wrong direction", []string{"analyst"}, Access{Direction: DirectionImport, Profile: "warehouse", Databases: []string{"sales"}}, false},
+		{"other profile", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "crm", Databases: []string{"sales"}}, false},
+		{"other database", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"hr"}}, false},
+		{"one of the databases denied", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"sales", "hr"}}, false},
+		{"inline connection without grant", []string{"analyst"}, Access{Direction: DirectionExport, Databases: []string{"sales"}}, false},
+		{"inline connection", []string{"loader"}, Access{Direction: DirectionImport, Databases: []string{"staging"}}, true},
+		{"profile wildcard", []string{"loader"}, Access{Direction: DirectionImport, Profile: "any", Databases: []string{"staging"}}, true},
+		{"custom query needs all databases", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "warehouse", CustomQuery: true}, false},
+		{"custom query", []string{"explorer"}, Access{Direction: DirectionExport, Profile: "warehouse", CustomQuery: true}, true},
+		{"granted server dir", []string{"loader"}, Access{Direction: DirectionImport, Databases: []string{"staging"}, ServerDir: "incoming"}, true},
+		{"other server dir", []string{"loader"}, Access{Direction: DirectionImport, Databases: []string{"staging"}, ServerDir: "etc"}, false},
+		{"granted s3 endpoint", []string{"analyst"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"sales"}, S3Endpoint: "archive"}, true},
+		{"s3 endpoint not granted", []string{"explorer"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"sales"}, S3Endpoint: "archive"}, false},
+		// Grants are not combined: no single role allows both
+		{"split across roles", []string{"analyst", "explorer"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"hr"}, S3Endpoint: "archive"}, false},
+		{"unknown role", []string{"root"}, Access{Direction: DirectionExport, Profile: "warehouse", Databases: []string{"sales"}}, false},
+		{"no roles", nil, Access{Direction: DirectionExport, Profile: "warehouse"}, false},
+	}
+	for _, tt := range tests {
+		err := principalWith(t, tt.roles...).Authorize(tt.access)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: got %v, want ErrForbidden", tt.name, err)
+		}
+	}
+}
+
+func TestPrincipalKeepsOnlyConfiguredRoles(t *testing.T) {
+	p := principalWith(t, "analyst", "root", "admin")
+	if len(p.Roles) != 2 || p.Roles[0] != "analyst" || p.Roles[1] != "admin" {
+		t.Errorf("got roles %v", p.Roles)
+	}
+	if !p.IsAdmin() {
+		t.Error("want admin")
+	}
+	if principalWith(t, "analyst", "root").IsAdmin() {
+		t.Error("unknown role made the principal an admin")
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	sum := sha256.Sum256([]byte("s3cret"))
+	a, err := NewAuthenticator(config.AuthConfig{
+		Enabled: true,
+		APIKeys: []config.APIKeyConfig{{Name: "etl", KeySHA256: hex.EncodeToString(sum[:]), Roles: []string{"loader"}}},
+		Roles:   testRoles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		user   string
+	}{
+		{"api key header", "X-API-Key", "s3cret", "etl"},
+		{"bearer api key", "Authorization", "Bearer s3cret", "etl"},
+		{"wrong key", "X-API-Key", "guess", ""},
+		{"no credentials", "", "", ""},
+		{"other scheme", "Authorization", "Basic s3cret", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/x", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		p, err := a.authenticate(r)
+		if tt.user == "" {
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("%s: got %v, want ErrUnauthenticated", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || p.Name != tt.user || len(p.Roles) != 1 {
+			t.Errorf("%s: got %+v, %v", tt.name, p, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/config"
+)
+
+// jwtVerifier checks tokens issued by an OIDC provider against keys from a
+// local JWKS file. Only asymmetric algorithms are accepted, so a token
+// cannot be forged from the public keys.
+type jwtVerifier struct {
+	cfg  config.JWTConfig
+	keys map[string]crypto.PublicKey
+	now  func() time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func newJWTVerifier(cfg config.JWTConfig) (*jwtVerifier, error) {
+	data, err := os.ReadFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %v", err)
+	}
+
+	v := &jwtVerifier{cfg: cfg, keys: make(map[string]crypto.PublicKey), now: time.Now}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		public, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", key.Kid, err)
+		}
+		v.keys[key.Kid] = public
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s has no signing keys", cfg.JWKSFile)
+	}
+	return v, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verify checks the signature and standard claims of token and returns the
+// user and roles it carries.
+func (v *jwtVerifier) verify(token string) (string, []string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", nil, fmt.Errorf("malformed token header")
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown signing key %q", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed token signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(key, header.Alg, hash, h.Sum(nil), signature); err != nil {
+		return "", nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", nil, fmt.Errorf("malformed token claims")
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return "", nil, err
+	}
+
+	user, _ := claims[v.cfg.UserClaim].(string)
+	if user == "" {
+		return "", nil, fmt.Errorf("token has no %s claim", v.cfg.UserClaim)
+	}
+	return user, stringsClaim(claims[v.cfg.RolesClaim]), nil
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, signature []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match EC key", alg)
+		}
+		// JWS encodes ECDSA signatures as the fixed-size concatenation r || s
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key")
+}
+
+func (v *jwtVerifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.cfg.Leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return fmt.Errorf("unexpected token issuer")
+		}
+	}
+	if v.cfg.Audience != "" && !containsString(stringsClaim(claims["aud"]), v.cfg.Audience) {
+		return fmt.Errorf("unexpected token audience")
+	}
+	return nil
+}
+
+// stringsClaim reads a claim that may be a list of strings or a single
+// space-separated string, as OIDC providers differ on roles and audiences.
+func stringsClaim(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var result []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"clickhouse-integration/internal/config"
+)
+
+var testNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// writeJWKS publishes the public halves of keys as kid "rsa" and "ec".
+func (k testKeys) writeJWKS(t *testing.T) string {
+	t.Helper()
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	set := map[string][]jwk{"keys": {
+		{Kid: "rsa", Kty: "RSA", Use: "sig", N: b64(k.rsa.N), E: b64(big.NewInt(int64(k.rsa.E)))},
+		{Kid: "ec", Kty: "EC", Crv: "P-256", X: b64(k.ec.X), Y: b64(k.ec.Y)},
+		{Kid: "enc", Kty: "RSA", Use: "enc", N: b64(k.rsa.N), E: b64(big.NewInt(int64(k.rsa.E)))},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "kid": kid}) + "." + segment(claims)
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		signature = []byte("forged")
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestVerifier(t *testing.T, keys testKeys) *jwtVerifier {
+	t.Helper()
+	v, err := newJWTVerifier(config.JWTConfig{
+		JWKSFile:   keys.writeJWKS(t),
+		Issuer:     "https://issuer.example",
+		Audience:   "ingest",
+		UserClaim:  "sub",
+		RolesClaim: "roles",
+		Leeway:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "alice",
+		"roles": []string{"reader", "writer"},
+		"iss":   "https://issuer.example",
+		"aud":   []string{"other", "ingest"},
+		"exp":   testNow.Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTVerifyAcceptsValidTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys)
+
+	for alg, kid := range map[string]string{"RS256": "rsa", "ES256": "ec"} {
+		user, roles, err := v.verify(keys.sign(t, alg, kid, validClaims()))
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+		if user != "alice" || len(roles) != 2 || roles[0] != "reader" || roles[1] != "writer" {
+			t.Errorf("%s: got %s %v", alg, user, roles)
+		}
+	}
+
+	claims := validClaims()
+	claims["roles"] = "reader writer"
+	claims["aud"] = "ingest"
+	if _, roles, err := v.verify(keys.sign(t, "RS256", "rsa", claims)); err != nil || len(roles) != 2 {
+		t.Errorf("space-separated claims: got %v, %v", roles, err)
+	}
+}
+
+func TestJWTVerifyRejectsInvalidTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys)
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"expired", keys.sign(t, "RS256", "rsa", with("exp", testNow.Add(-time.Hour).Unix())), "token expired"},
+		{"no expiry", keys.sign(t, "RS256", "rsa", with("exp", nil)), "no expiry"},
+		{"not yet valid", keys.sign(t, "RS256", "rsa", with("nbf", testNow.Add(time.Hour).Unix())), "not valid yet"},
+		{"wrong issuer", keys.sign(t, "RS256", "rsa", with("iss", "https://evil.example")), "issuer"},
+		{"wrong audience", keys.sign(t, "RS256", "rsa", with("aud", "other")), "audience"},
+		{"no user", keys.sign(t, "RS256", "rsa", with("sub", nil)), "no sub claim"},
+		{"alg none", keys.sign(t, "none", "rsa", validClaims()), "unsupported algorithm"},
+		{"hs256", keys.sign(t, "HS256", "rsa", validClaims()), "unsupported algorithm"},
+		{"unknown kid", keys.sign(t, "RS256", "other", validClaims()), "unknown signing key"},
+		{"encryption key", keys.sign(t, "RS256", "enc", validClaims()), "unknown signing key"},
+		{"alg does not match key", keys.sign(t, "ES256", "rsa", validClaims()), "does not match"},
+		{"malformed", "abc.def", "malformed"},
+	}
+
+	tampered := keys.sign(t, "RS256", "rsa", validClaims())
+	parts := strings.Split(tampered, ".")
+	claims := validClaims()
+	claims["sub"] = "admin"
+	data, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(data)
+	tests = append(tests, struct {
+		name  string
+		token string
+		want  string
+	}{"tampered claims", strings.Join(parts, "."), "invalid token signature"})
+
+	for _, tt := range tests {
+		_, _, err := v.verify(tt.token)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestJWTVerifyLeeway(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys)
+
+	tests := []struct {
+		name  string
+		claim string
+		at    time.Time
+		valid bool
+	}{
+		{"expired within leeway", "exp", testNow.Add(-time.Minute), true},
+		{"expired past leeway", "exp", testNow.Add(-time.Minute - time.Second), false},
+		{"starts within leeway", "nbf", testNow.Add(time.Minute), true},
+		{"starts past leeway", "nbf", testNow.Add(time.Minute + time.Second), false},
+	}
+	for _, tt := range tests {
+		claims := validClaims()
+		claims[tt.claim] = tt.at.Unix()
+		_, _, err := v.verify(keys.sign(t, "RS256", "rsa", claims))
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+	}
+}
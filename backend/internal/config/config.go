@@ -0,0 +1,506 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. It is built from Default, then the
+// YAML file if one is given, then environment variables, and validated
+// before any service is created.
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Storage    StorageConfig    `yaml:"storage"`
+	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
+	Profiles   ProfilesConfig   `yaml:"profiles"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Sources    SourcesConfig    `yaml:"sources"`
+	Log        LogConfig        `yaml:"log"`
+}
+
+// LogConfig selects the log level (debug, info, warn or error) and the
+// output format (json or text).
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// ServerConfig configures the HTTP listener. ReadHeaderTimeout guards
+// against slow clients; ReadTimeout covers the whole request body and is
+// off by default, since uploads and streamed imports can take long.
+type ServerConfig struct {
+	ListenAddr        string        `yaml:"listen_addr"`
+	CORSOrigins       []string      `yaml:"cors_origins"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+}
+
+type StorageConfig struct {
+	UploadDir     string        `yaml:"upload_dir"`
+	ExportDir     string        `yaml:"export_dir"`
+	StateDir      string        `yaml:"state_dir"`
+	MaxUploadSize int64         `yaml:"max_upload_size"`
+	ExportTTL     time.Duration `yaml:"export_ttl"`
+	UploadTTL     time.Duration `yaml:"upload_ttl"`
+	// MaxDiskUsage caps the bytes kept in the upload and export
+	// directories together; 0 disables the quota.
+	MaxDiskUsage    int64         `yaml:"max_disk_usage"`
+	JanitorInterval time.Duration `yaml:"janitor_interval"`
+	// Resumable uploads are not bound by MaxUploadSize, which only applies
+	// to single-request multipart uploads.
+	MaxResumableSize int64 `yaml:"max_resumable_size"`
+	MaxChunkSize     int64 `yaml:"max_chunk_size"`
+}
+
+// ClickHouseConfig holds the connection defaults used for any field a
+// request leaves empty, plus driver and job settings.
+type ClickHouseConfig struct {
+	Host             string        `yaml:"host"`
+	Port             int           `yaml:"port"`
+	HTTPPort         int           `yaml:"http_port"`
+	Database         string        `yaml:"database"`
+	User             string        `yaml:"user"`
+	Password         string        `yaml:"password"`
+	Debug            bool          `yaml:"debug"`
+	DialTimeout      time.Duration `yaml:"dial_timeout"`
+	MaxExecutionTime int           `yaml:"max_execution_time"`
+	BatchSize        int           `yaml:"batch_size"`
+	InsertWorkers    int           `yaml:"insert_workers"`
+	ExportWorkers    int           `yaml:"export_workers"`
+	// MaxExportSlices caps the slices of one parallel export, each of
+	// which is a query over the table.
+	MaxExportSlices int `yaml:"max_export_slices"`
+	// Compression is the default for the native protocol: none, lz4 or
+	// zstd. HTTPCompression is the default for raw imports over HTTP:
+	// none, gzip, deflate or br.
+	Compression     string      `yaml:"compression"`
+	HTTPCompression string      `yaml:"http_compression"`
+	QueryLimits     QueryLimits `yaml:"query_limits"`
+}
+
+// QueryLimits mirrors services.QueryLimits field for field so it converts
+// directly.
+type QueryLimits struct {
+	MaxResultRows    uint64 `yaml:"max_result_rows"`
+	MaxExecutionTime int    `yaml:"max_execution_time"`
+	MaxMemoryUsage   uint64 `yaml:"max_memory_usage"`
+}
+
+type ProfilesConfig struct {
+	// SecretKey encrypts stored profile secrets. When empty a random key
+	// is generated into the state directory on first start.
+	SecretKey string `yaml:"secret_key"`
+}
+
+// AuthConfig protects the /api routes. Callers authenticate with one of the
+// API keys or with a JWT signed by a key in the JWKS file, and are then
+// limited by the roles attached to the key or carried in the token.
+type AuthConfig struct {
+	Enabled bool                  `yaml:"enabled"`
+	APIKeys []APIKeyConfig        `yaml:"api_keys"`
+	JWT     JWTConfig             `yaml:"jwt"`
+	Roles   map[string]RoleConfig `yaml:"roles"`
+}
+
+// APIKeyConfig stores only the SHA-256 of the key, hex encoded, so the
+// config file does not hold usable credentials.
+type APIKeyConfig struct {
+	Name      string   `yaml:"name"`
+	KeySHA256 string   `yaml:"key_sha256"`
+	Roles     []string `yaml:"roles"`
+}
+
+type JWTConfig struct {
+	JWKSFile   string        `yaml:"jwks_file"`
+	Issuer     string        `yaml:"issuer"`
+	Audience   string        `yaml:"audience"`
+	UserClaim  string        `yaml:"user_claim"`
+	RolesClaim string        `yaml:"roles_claim"`
+	Leeway     time.Duration `yaml:"leeway"`
+}
+
+// RoleConfig grants access. Profiles and Databases list allowed names, with
+// "*" matching any; Directions holds "import" and/or "export". Inline
+// connections that name no profile need InlineConnections. ServerDirs and
+// S3 grant the named sources.server_dirs and sources.s3 entries the same
+// way, for imports and S3 exports. Admin grants everything, including
+// managing connection profiles.
+type RoleConfig struct {
+	Admin             bool     `yaml:"admin"`
+	Profiles          []string `yaml:"profiles"`
+	Databases         []string `yaml:"databases"`
+	Directions        []string `yaml:"directions"`
+	InlineConnections bool     `yaml:"inline_connections"`
+	ServerDirs        []string `yaml:"server_dirs"`
+	S3                []string `yaml:"s3"`
+}
+
+// SourcesConfig lists the places imports may read from besides uploads.
+// Everything is referenced by name so requests never carry server paths or
+// storage credentials.
+type SourcesConfig struct {
+	// ServerDirs maps a name to a directory on the server; requests give
+	// the name and a path inside it.
+	ServerDirs map[string]string `yaml:"server_dirs"`
+	// URLHosts allows HTTP(S) imports from these hosts; "*" allows any.
+	// URL imports are disabled when empty.
+	URLHosts []string            `yaml:"url_hosts"`
+	S3       map[string]S3Config `yaml:"s3"`
+}
+
+// S3Config is an S3-compatible endpoint such as AWS S3 or MinIO.
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// PathStyle addresses buckets as endpoint/bucket instead of
+	// bucket.endpoint, as MinIO and most stand-ins expect.
+	PathStyle bool `yaml:"path_style"`
+	// Buckets limits requests to these buckets; empty allows any the
+	// credentials reach.
+	Buckets []string `yaml:"buckets"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddr:        ":8080",
+			CORSOrigins:       []string{"*"},
+			ReadHeaderTimeout: 30 * time.Second,
+			WriteTimeout:      30 * time.Minute,
+		},
+		Storage: StorageConfig{
+			UploadDir:        "uploads",
+			ExportDir:        "exports",
+			StateDir:         "state",
+			MaxUploadSize:    10 * 1024 * 1024,
+			ExportTTL:        24 * time.Hour,
+			UploadTTL:        24 * time.Hour,
+			JanitorInterval:  10 * time.Minute,
+			MaxResumableSize: 50 * 1024 * 1024 * 1024,
+			MaxChunkSize:     64 * 1024 * 1024,
+		},
+		ClickHouse: ClickHouseConfig{
+			Host:             "127.0.0.1",
+			Port:             9000,
+			HTTPPort:         8123,
+			Database:         "default",
+			User:             "default",
+			Password:         "password",
+			DialTimeout:      30 * time.Second,
+			MaxExecutionTime: 60,
+			BatchSize:        10000,
+			InsertWorkers:    4,
+			ExportWorkers:    4,
+			MaxExportSlices:  64,
+			Compression:      "none",
+			HTTPCompression:  "none",
+			QueryLimits: QueryLimits{
+				MaxResultRows:    1000000,
+				MaxExecutionTime: 60,
+				MaxMemoryUsage:   4 * 1024 * 1024 * 1024,
+			},
+		},
+		Auth: AuthConfig{
+			JWT: JWTConfig{
+				UserClaim:  "sub",
+				RolesClaim: "roles",
+				Leeway:     time.Minute,
+			},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// Load reads the configuration. path may be empty to use only defaults and
+// environment variables. Unknown keys in the file are an error so typos do
+// not silently fall back to defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.ListenAddr != "", "server.listen_addr is required")
+	check(len(c.Server.CORSOrigins) > 0, "server.cors_origins needs at least one origin")
+	check(c.Server.ReadHeaderTimeout >= 0, "server.read_header_timeout must not be negative")
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout must not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout must not be negative")
+
+	check(c.Storage.UploadDir != "", "storage.upload_dir is required")
+	check(c.Storage.ExportDir != "", "storage.export_dir is required")
+	check(c.Storage.StateDir != "", "storage.state_dir is required")
+	check(c.Storage.MaxUploadSize > 0, "storage.max_upload_size must be positive")
+	check(c.Storage.ExportTTL > 0, "storage.export_ttl must be positive")
+	check(c.Storage.UploadTTL > 0, "storage.upload_ttl must be positive")
+	check(c.Storage.MaxDiskUsage >= 0, "storage.max_disk_usage must not be negative")
+	check(c.Storage.JanitorInterval > 0, "storage.janitor_interval must be positive")
+	check(c.Storage.MaxResumableSize > 0, "storage.max_resumable_size must be positive")
+	check(c.Storage.MaxChunkSize > 0, "storage.max_chunk_size must be positive")
+
+	check(c.ClickHouse.Port > 0 && c.ClickHouse.Port < 65536, "clickhouse.port %d is out of range", c.ClickHouse.Port)
+	check(c.ClickHouse.HTTPPort > 0 && c.ClickHouse.HTTPPort < 65536, "clickhouse.http_port %d is out of range", c.ClickHouse.HTTPPort)
+	check(c.ClickHouse.DialTimeout > 0, "clickhouse.dial_timeout must be positive")
+	check(c.ClickHouse.MaxExecutionTime >= 0, "clickhouse.max_execution_time must not be negative")
+	check(c.ClickHouse.BatchSize > 0, "clickhouse.batch_size must be positive")
+	check(c.ClickHouse.InsertWorkers > 0, "clickhouse.insert_workers must be positive")
+	check(c.ClickHouse.ExportWorkers > 0, "clickhouse.export_workers must be positive")
+	check(c.ClickHouse.MaxExportSlices > 0, "clickhouse.max_export_slices must be positive")
+	check(oneOf(c.ClickHouse.Compression, "none", "lz4", "zstd"),
+		"clickhouse.compression %q must be none, lz4 or zstd", c.ClickHouse.Compression)
+	check(oneOf(c.ClickHouse.HTTPCompression, "none", "gzip", "deflate", "br"),
+		"clickhouse.http_compression %q must be none, gzip, deflate or br", c.ClickHouse.HTTPCompression)
+	check(oneOf(c.Log.Level, "debug", "info", "warn", "error"),
+		"log.level %q must be debug, info, warn or error", c.Log.Level)
+	check(oneOf(c.Log.Format, "json", "text"), "log.format %q must be json or text", c.Log.Format)
+	check(c.ClickHouse.QueryLimits.MaxExecutionTime >= 0, "clickhouse.query_limits.max_execution_time must not be negative")
+
+	if c.Auth.Enabled {
+		check(len(c.Auth.APIKeys) > 0 || c.Auth.JWT.JWKSFile != "", "auth needs api_keys or jwt.jwks_file when enabled")
+		for i, key := range c.Auth.APIKeys {
+			check(key.Name != "", "auth.api_keys[%d].name is required", i)
+			check(len(key.KeySHA256) == 64, "auth.api_keys[%d].key_sha256 must be a hex SHA-256", i)
+			for _, role := range key.Roles {
+				_, ok := c.Auth.Roles[role]
+				check(ok, "auth.api_keys[%d] uses undefined role %q", i, role)
+			}
+		}
+		for name, role := range c.Auth.Roles {
+			for _, direction := range role.Directions {
+				check(direction == "import" || direction == "export", "auth.roles.%s has unknown direction %q", name, direction)
+			}
+			for _, dir := range role.ServerDirs {
+				_, ok := c.Sources.ServerDirs[dir]
+				check(ok || dir == "*", "auth.roles.%s grants undefined server dir %q", name, dir)
+			}
+			for _, endpoint := range role.S3 {
+				_, ok := c.Sources.S3[endpoint]
+				check(ok || endpoint == "*", "auth.roles.%s grants undefined s3 endpoint %q", name, endpoint)
+			}
+		}
+	}
+	for name, dir := range c.Sources.ServerDirs {
+		check(filepath.IsAbs(dir), "sources.server_dirs.%s must be an absolute path", name)
+	}
+	for name, s3 := range c.Sources.S3 {
+		u, err := url.Parse(s3.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "sources.s3.%s.endpoint must be an http(s) URL", name)
+		check(s3.Region != "", "sources.s3.%s.region is required", name)
+	}
+
+	// Browsers refuse credentialed requests to a wildcard origin anyway, and
+	// reflecting any origin with credentials would let every site call the API
+	for _, origin := range c.Server.CORSOrigins {
+		check(origin != "*" || len(c.Server.CORSOrigins) == 1, "server.cors_origins cannot mix \"*\" with explicit origins")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// StatePath returns the path of a file in the state directory.
+func (c *Config) StatePath(name string) string {
+	return filepath.Join(c.Storage.StateDir, name)
+}
+
+// applyEnv overrides settings from environment variables. PORT and
+// MAX_UPLOAD_SIZE keep the names the server has always read.
+func (c *Config) applyEnv() error {
+	env := envReader{}
+
+	if port := os.Getenv("PORT"); port != "" {
+		c.Server.ListenAddr = ":" + port
+	}
+	env.str("LISTEN_ADDR", &c.Server.ListenAddr)
+	env.list("CORS_ORIGINS", &c.Server.CORSOrigins)
+	env.duration("READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	env.duration("READ_TIMEOUT", &c.Server.ReadTimeout)
+	env.duration("WRITE_TIMEOUT", &c.Server.WriteTimeout)
+
+	env.str("UPLOAD_DIR", &c.Storage.UploadDir)
+	env.str("EXPORT_DIR", &c.Storage.ExportDir)
+	env.str("STATE_DIR", &c.Storage.StateDir)
+	env.int64("MAX_UPLOAD_SIZE", &c.Storage.MaxUploadSize)
+	env.duration("EXPORT_TTL", &c.Storage.ExportTTL)
+	env.duration("UPLOAD_TTL", &c.Storage.UploadTTL)
+	env.int64("MAX_DISK_USAGE", &c.Storage.MaxDiskUsage)
+	env.duration("JANITOR_INTERVAL", &c.Storage.JanitorInterval)
+	env.int64("MAX_RESUMABLE_SIZE", &c.Storage.MaxResumableSize)
+	env.int64("MAX_CHUNK_SIZE", &c.Storage.MaxChunkSize)
+
+	env.str("CLICKHOUSE_HOST", &c.ClickHouse.Host)
+	env.int("CLICKHOUSE_PORT", &c.ClickHouse.Port)
+	env.int("CLICKHOUSE_HTTP_PORT", &c.ClickHouse.HTTPPort)
+	env.str("CLICKHOUSE_DATABASE", &c.ClickHouse.Database)
+	env.str("CLICKHOUSE_USER", &c.ClickHouse.User)
+	env.str("CLICKHOUSE_PASSWORD", &c.ClickHouse.Password)
+	env.bool("CLICKHOUSE_DEBUG", &c.ClickHouse.Debug)
+	env.duration("CLICKHOUSE_DIAL_TIMEOUT", &c.ClickHouse.DialTimeout)
+	env.int("CLICKHOUSE_MAX_EXECUTION_TIME", &c.ClickHouse.MaxExecutionTime)
+	env.int("CLICKHOUSE_BATCH_SIZE", &c.ClickHouse.BatchSize)
+	env.int("CLICKHOUSE_INSERT_WORKERS", &c.ClickHouse.InsertWorkers)
+	env.int("CLICKHOUSE_EXPORT_WORKERS", &c.ClickHouse.ExportWorkers)
+	env.int("CLICKHOUSE_MAX_EXPORT_SLICES", &c.ClickHouse.MaxExportSlices)
+	env.str("CLICKHOUSE_COMPRESSION", &c.ClickHouse.Compression)
+	env.str("CLICKHOUSE_HTTP_COMPRESSION", &c.ClickHouse.HTTPCompression)
+
+	env.uint64("QUERY_MAX_RESULT_ROWS", &c.ClickHouse.QueryLimits.MaxResultRows)
+	env.int("QUERY_MAX_EXECUTION_TIME", &c.ClickHouse.QueryLimits.MaxExecutionTime)
+	env.uint64("QUERY_MAX_MEMORY_USAGE", &c.ClickHouse.QueryLimits.MaxMemoryUsage)
+
+	env.str("PROFILE_SECRET_KEY", &c.Profiles.SecretKey)
+
+	env.bool("AUTH_ENABLED", &c.Auth.Enabled)
+	env.str("AUTH_JWKS_FILE", &c.Auth.JWT.JWKSFile)
+	env.str("AUTH_JWT_ISSUER", &c.Auth.JWT.Issuer)
+	env.str("AUTH_JWT_AUDIENCE", &c.Auth.JWT.Audience)
+
+	env.str("LOG_LEVEL", &c.Log.Level)
+	env.str("LOG_FORMAT", &c.Log.Format)
+
+	return env.err()
+}
+
+// envReader collects parse errors so every bad variable is reported at once.
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) lookup(name string) (string, bool) {
+	value, ok := os.LookupEnv(name)
+	return value, ok && value != ""
+}
+
+func (e *envReader) fail(name, value string, err error) {
+	e.problems = append(e.problems, fmt.Sprintf("%s=%q: %v", name, value, err))
+}
+
+func (e *envReader) str(name string, dst *string) {
+	if value, ok := e.lookup(name); ok {
+		*dst = value
+	}
+}
+
+func (e *envReader) list(name string, dst *[]string) {
+	if value, ok := e.lookup(name); ok {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+func (e *envReader) int(name string, dst *int) {
+	if value, ok := e.lookup(name); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.fail(name, value, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) int64(name string, dst *int64) {
+	if value, ok := e.lookup(name); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.fail(name, value, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) uint64(name string, dst *uint64) {
+	if value, ok := e.lookup(name); ok {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			e.fail(name, value, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) bool(name string, dst *bool) {
+	if value, ok := e.lookup(name); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.fail(name, value, err)
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	if value, ok := e.lookup(name); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.fail(name, value, err)
+			return
+		}
+		*dst = d
+	}
+}
+
+func (e *envReader) err() error {
+	if len(e.problems) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(e.problems, "; "))
+	}
+	return nil
+}
@@ -1,19 +1,32 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/gin-gonic/gin"
+	"clickhouse-integration/internal/auth"
 	"clickhouse-integration/internal/models"
 	"clickhouse-integration/internal/services"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/gin-gonic/gin"
 )
 
 type ClickHouseHandler struct {
-	service *services.ClickHouseService
+	service       *services.ClickHouseService
+	fileService   *services.FileService
+	sourceService *services.SourceService
+	logger        *slog.Logger
 }
 
-func NewClickHouseHandler(service *services.ClickHouseService) *ClickHouseHandler {
-	return &ClickHouseHandler{service: service}
+func NewClickHouseHandler(service *services.ClickHouseService, fileService *services.FileService, sourceService *services.SourceService, logger *slog.Logger) *ClickHouseHandler {
+	return &ClickHouseHandler{service: service, fileService: fileService, sourceService: sourceService, logger: logger}
 }
 
 func (h *ClickHouseHandler) Connect(c *gin.Context) {
@@ -26,9 +39,9 @@ func (h *ClickHouseHandler) Connect(c *gin.Context) {
 		return
 	}
 
-	conn, err := h.service.Connect(config)
+	conn, err := h.connect(c, &config, auth.Access{Direction: auth.DirectionExport})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+		c.JSON(connectErrorStatus(err), models.Response{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -42,7 +55,7 @@ func (h *ClickHouseHandler) Connect(c *gin.Context) {
 	})
 }
 
-func (h *ClickHouseHandler) GetTables(c *gin.Context) {
+func (h *ClickHouseHandler) GetDatabases(c *gin.Context) {
 	var config models.ClickHouseConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{
@@ -52,7 +65,17 @@ func (h *ClickHouseHandler) GetTables(c *gin.Context) {
 		return
 	}
 
-	conn, err := h.service.Connect(config)
+	conn, err := h.connect(c, &config, auth.Access{Direction: auth.DirectionExport})
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	databases, err := h.service.GetDatabases(conn)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
@@ -60,6 +83,41 @@ func (h *ClickHouseHandler) GetTables(c *gin.Context) {
 		})
 		return
 	}
+
+	// List only the databases the caller's roles grant
+	visible := databases[:0]
+	for _, database := range databases {
+		access := auth.Access{Direction: auth.DirectionExport, Profile: config.Profile, Databases: []string{database.Name}}
+		if auth.Authorize(c, access) == nil {
+			visible = append(visible, database)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    visible,
+	})
+}
+
+func (h *ClickHouseHandler) GetTables(c *gin.Context) {
+	var config models.ClickHouseConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	config.Database = c.DefaultQuery("database", config.Database)
+	conn, err := h.connect(c, &config, auth.Access{Direction: auth.DirectionExport})
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
 	defer conn.Close()
 
 	tables, err := h.service.GetTables(conn, config.Database)
@@ -96,9 +154,10 @@ func (h *ClickHouseHandler) GetColumns(c *gin.Context) {
 		return
 	}
 
-	conn, err := h.service.Connect(config)
+	config.Database = c.DefaultQuery("database", config.Database)
+	conn, err := h.connect(c, &config, auth.Access{Direction: auth.DirectionExport}, table)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+		c.JSON(connectErrorStatus(err), models.Response{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -121,6 +180,52 @@ func (h *ClickHouseHandler) GetColumns(c *gin.Context) {
 	})
 }
 
+func (h *ClickHouseHandler) GetTableStats(c *gin.Context) {
+	var config models.ClickHouseConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	table := c.Param("table")
+	if table == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Table name is required",
+		})
+		return
+	}
+	topN, _ := strconv.Atoi(c.Query("top"))
+
+	config.Database = c.DefaultQuery("database", config.Database)
+	conn, err := h.connect(c, &config, auth.Access{Direction: auth.DirectionExport}, table)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	stats, err := h.service.GetTableStats(conn, config.Database, table, topN)
+	if err != nil {
+		c.JSON(exportErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    stats,
+	})
+}
+
 func (h *ClickHouseHandler) ExportData(c *gin.Context) {
 	var req models.ExportRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -131,9 +236,32 @@ func (h *ClickHouseHandler) ExportData(c *gin.Context) {
 		return
 	}
 
-	conn, err := h.service.Connect(req.Config)
+	if req.Output == models.ExportOutputS3 && req.Destination == nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "destination is required for s3 output",
+		})
+		return
+	}
+	perSlice := req.Parallel != nil && req.Parallel.PerSlice
+	if perSlice && req.Output != models.ExportOutputFile {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "one file per slice needs the file output",
+		})
+		return
+	}
+
+	access := auth.Access{
+		Direction:   auth.DirectionExport,
+		CustomQuery: req.Query != "",
+	}
+	if req.Output == models.ExportOutputS3 {
+		access.S3Endpoint = req.Destination.Endpoint
+	}
+	conn, err := h.connect(c, &req.Config, access, tableList(req.Table)...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+		c.JSON(connectErrorStatus(err), models.Response{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -141,8 +269,33 @@ func (h *ClickHouseHandler) ExportData(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	data, err := h.service.ExportData(conn, req)
+	if perSlice {
+		h.writeExportSliceFiles(c, conn, req)
+		return
+	}
+
+	logger := requestLogger(c, h.logger).With("table", req.Table)
+	result, err := h.service.ExportData(conn, req)
 	if err != nil {
+		logger.Error("export failed", "error", err)
+		c.JSON(exportErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	logger.Info("export finished", "rows", len(result.Rows), "slices", len(result.Slices), "output", req.Output)
+
+	switch req.Output {
+	case models.ExportOutputFile:
+		h.writeExportFile(c, req, result)
+		return
+	case models.ExportOutputS3:
+		h.writeExportS3(c, req, result)
+		return
+	}
+
+	if err := h.service.CommitWatermark(result.Watermark); err != nil {
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
 			Error:   err.Error(),
@@ -152,10 +305,336 @@ func (h *ClickHouseHandler) ExportData(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
+		Data:    result,
+	})
+}
+
+// connect resolves a connection profile into config in place, so later
+// uses of the config see the profile's host and database, checks that the
+// caller may access it, and opens a connection.
+func (h *ClickHouseHandler) connect(c *gin.Context, config *models.ClickHouseConfig, access auth.Access, tables ...string) (driver.Conn, error) {
+	if err := h.authorize(c, config, access, tables...); err != nil {
+		return nil, err
+	}
+	return h.service.Connect(*config)
+}
+
+// authorize resolves config in place and checks that the caller may access
+// its profile and the databases of tables. Without tables the config's
+// database is checked.
+func (h *ClickHouseHandler) authorize(c *gin.Context, config *models.ClickHouseConfig, access auth.Access, tables ...string) error {
+	resolved, err := h.service.ResolveConfig(*config)
+	if err != nil {
+		return err
+	}
+	*config = resolved
+
+	access.Profile = resolved.Profile
+	access.Databases = []string{resolved.Database}
+	if len(tables) > 0 {
+		access.Databases = access.Databases[:0]
+		for _, table := range tables {
+			access.Databases = append(access.Databases, tableDatabase(resolved.Database, table))
+		}
+	}
+	return auth.Authorize(c, access)
+}
+
+// tableDatabase returns the database of a possibly qualified table name.
+func tableDatabase(database, table string) string {
+	if db, _, found := strings.Cut(table, "."); found {
+		return db
+	}
+	return database
+}
+
+func tableList(table string) []string {
+	if table == "" {
+		return nil
+	}
+	return []string{table}
+}
+
+func connectErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrProfileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrCredentialsRequired):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// exportErrorStatus distinguishes rejected queries and exceeded sandbox
+// limits from server failures.
+func exportErrorStatus(err error) int {
+	var limitErr *services.QueryLimitError
+	switch {
+	case errors.Is(err, services.ErrQueryNotAllowed), errors.Is(err, services.ErrCredentialsRequired):
+		return http.StatusBadRequest
+	case errors.As(err, &limitErr):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *ClickHouseHandler) writeExportFile(c *gin.Context, req models.ExportRequest, result *models.ExportResult) {
+	delimiter := ','
+	if req.Delimiter != "" {
+		delimiter = rune(req.Delimiter[0])
+	}
+
+	formatter, err := services.NewValueFormatter(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	names := make([]string, len(result.Columns))
+	types := make([]string, len(result.Columns))
+	for i, col := range result.Columns {
+		names[i] = col.Name
+		types[i] = col.Type
+	}
+
+	export, err := h.fileService.WriteExport(names, types, result.Rows, delimiter, formatter, principalName(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.CommitWatermark(result.Watermark); err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Export written to file",
+		Data: models.ExportFileResult{
+			FileID:      export.ID,
+			DownloadURL: "/api/file/download/" + export.ID,
+			Rows:        len(result.Rows),
+			Size:        export.Size,
+			ExpiresAt:   export.ExpiresAt,
+			Watermark:   result.Watermark,
+			Wire:        result.Wire,
+		},
+	})
+}
+
+// writeExportSliceFiles runs a parallel export and writes each slice to its
+// own file as soon as it is read, so the slices are never held together.
+func (h *ClickHouseHandler) writeExportSliceFiles(c *gin.Context, conn driver.Conn, req models.ExportRequest) {
+	delimiter := ','
+	if req.Delimiter != "" {
+		delimiter = rune(req.Delimiter[0])
+	}
+
+	formatter, err := services.NewValueFormatter(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	var mu sync.Mutex
+	files := make(map[int]models.ExportFileResult)
+	slices, err := h.service.ExportSlices(conn, req, func(slice models.ExportSlice, result *models.ExportResult) error {
+		names := make([]string, len(result.Columns))
+		types := make([]string, len(result.Columns))
+		for i, col := range result.Columns {
+			names[i] = col.Name
+			types[i] = col.Type
+		}
+
+		export, err := h.fileService.WriteExport(names, types, result.Rows, delimiter, formatter, principalName(c))
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		files[slice.Index] = models.ExportFileResult{
+			FileID:      export.ID,
+			DownloadURL: "/api/file/download/" + export.ID,
+			Rows:        len(result.Rows),
+			Size:        export.Size,
+			ExpiresAt:   export.ExpiresAt,
+			Slice:       slice.Label,
+			Wire:        result.Wire,
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(exportErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	data := models.ExportSliceFiles{Slices: slices}
+	for _, slice := range slices {
+		data.Files = append(data.Files, files[slice.Index])
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: fmt.Sprintf("Export written to %d files", len(data.Files)),
 		Data:    data,
 	})
 }
 
+func (h *ClickHouseHandler) writeExportS3(c *gin.Context, req models.ExportRequest, result *models.ExportResult) {
+	delimiter := ','
+	if req.Delimiter != "" {
+		delimiter = rune(req.Delimiter[0])
+	}
+
+	formatter, err := services.NewValueFormatter(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	manifest, manifestKey, err := h.sourceService.WriteExportS3(c.Request.Context(), *req.Destination, req.PartitionBy, result, delimiter, formatter)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.CommitWatermark(result.Watermark); err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: fmt.Sprintf("Export written to %d objects", len(manifest.Objects)),
+		Data: models.ExportS3Result{
+			ManifestKey: manifestKey,
+			Manifest:    manifest,
+			Watermark:   result.Watermark,
+			Wire:        result.Wire,
+		},
+	})
+}
+
+func (h *ClickHouseHandler) Preview(c *gin.Context) {
+	var req models.PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	conn, err := h.connect(c, &req.Config, auth.Access{
+		Direction:   auth.DirectionExport,
+		CustomQuery: req.Query != "",
+	}, tableList(req.Table)...)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	result, err := h.service.PreviewData(conn, req)
+	if err != nil {
+		c.JSON(exportErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    result,
+	})
+}
+
+func (h *ClickHouseHandler) CopyTable(c *gin.Context) {
+	var req models.CopyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	source, err := h.connect(c, &req.Source, auth.Access{Direction: auth.DirectionExport}, req.SourceTable)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   "source: " + err.Error(),
+		})
+		return
+	}
+	defer source.Close()
+
+	targetTable := req.TargetTable
+	if targetTable == "" {
+		targetTable = req.SourceTable
+	}
+	target, err := h.connect(c, &req.Target, auth.Access{Direction: auth.DirectionImport}, targetTable)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   "target: " + err.Error(),
+		})
+		return
+	}
+	defer target.Close()
+
+	logger := requestLogger(c, h.logger).With("source_table", req.SourceTable, "target_table", targetTable)
+	result, err := h.service.CopyTable(source, target, req)
+	if err != nil {
+		logger.Error("copy failed", "error", err)
+		c.JSON(exportErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	logger.Info("table copied", "mode", result.Mode, "rows", result.RowsCopied, "batches", result.Batches)
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Table copied successfully",
+		Data:    result,
+	})
+}
+
 func (h *ClickHouseHandler) ImportData(c *gin.Context) {
 	var req models.ImportRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -166,9 +645,9 @@ func (h *ClickHouseHandler) ImportData(c *gin.Context) {
 		return
 	}
 
-	conn, err := h.service.Connect(req.Config)
+	conn, err := h.connect(c, &req.Config, auth.Access{Direction: auth.DirectionImport}, req.Table)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+		c.JSON(connectErrorStatus(err), models.Response{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -176,16 +655,149 @@ func (h *ClickHouseHandler) ImportData(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	if err := h.service.ImportData(conn, req); err != nil {
+	logger := requestLogger(c, h.logger).With("table", req.Table)
+	metrics, err := h.service.ImportData(conn, req)
+	if err != nil {
+		logger.Error("import failed", "error", err)
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
+	logImport(logger, metrics)
 
+	message := "Data imported successfully"
+	if metrics.Status == models.ImportAccepted {
+		message = "Data accepted for asynchronous insert"
+	}
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
-		Message: "Data imported successfully",
+		Message: message,
+		Data:    metrics,
+	})
+}
+
+// Ingest starts a server-side ingestion and answers 202 with the job to
+// poll at /clickhouse/ingest/:id.
+func (h *ClickHouseHandler) Ingest(c *gin.Context) {
+	var req models.IngestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = "CSVWithNames"
+	}
+	structure, err := services.IngestStructure(req.Columns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	fn, args, err := h.sourceService.TableFunction(req.Source, req.Format, structure)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	conn, err := h.connect(c, &req.Config, sourceAccess(auth.Access{Direction: auth.DirectionImport}, req.Source), req.Table)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// The job owns conn from here on
+	job, err := h.service.StartIngest(conn, req, fn, args, principalName(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	requestLogger(c, h.logger).Info("ingestion queued", "job_id", job.ID, "table", req.Table)
+
+	c.JSON(http.StatusAccepted, models.Response{
+		Success: true,
+		Message: "Ingestion started",
+		Data:    job,
 	})
-} 
\ No newline at end of file
+}
+
+func (h *ClickHouseHandler) IngestStatus(c *gin.Context) {
+	job, err := h.service.IngestJob(c.Param("id"), ownerScope(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    job,
+	})
+}
+
+// ImportRaw streams a file to ClickHouse in its own format over the HTTP
+// interface. Like Ingest it answers 202 with a job to poll.
+func (h *ClickHouseHandler) ImportRaw(c *gin.Context) {
+	var req models.RawImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	access := sourceAccess(auth.Access{Direction: auth.DirectionImport}, req.Source)
+	if err := h.authorize(c, &req.Config, access, req.Table); err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// The stream outlives this request, so it must not use its context
+	body, name, err := h.sourceService.Open(context.Background(), req.Source, ownerScope(c))
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// The job owns body from here on
+	job, err := h.service.StartRawInsert(req.Config, req, body, name, principalName(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	requestLogger(c, h.logger).Info("raw insert queued", "job_id", job.ID, "table", req.Table)
+
+	c.JSON(http.StatusAccepted, models.Response{
+		Success: true,
+		Message: "Import started",
+		Data:    job,
+	})
+}
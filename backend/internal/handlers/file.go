@@ -2,14 +2,17 @@ package handlers
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
-	"time"
 
+	"clickhouse-integration/internal/auth"
+	"clickhouse-integration/internal/logging"
 	"clickhouse-integration/internal/models"
 	"clickhouse-integration/internal/services"
 
@@ -18,21 +21,19 @@ import (
 
 type FileHandler struct {
 	service           *services.FileService
+	sourceService     *services.SourceService
 	clickHouseService *services.ClickHouseService
 	maxSize           int64
+	logger            *slog.Logger
 }
 
-func NewFileHandler(fileService *services.FileService, clickHouseService *services.ClickHouseService) *FileHandler {
-	maxSize := int64(10 * 1024 * 1024) // Default 10MB
-	if envSize := os.Getenv("MAX_UPLOAD_SIZE"); envSize != "" {
-		if size, err := strconv.ParseInt(envSize, 10, 64); err == nil {
-			maxSize = size
-		}
-	}
+func NewFileHandler(fileService *services.FileService, sourceService *services.SourceService, clickHouseService *services.ClickHouseService, maxSize int64, logger *slog.Logger) *FileHandler {
 	return &FileHandler{
 		service:           fileService,
+		sourceService:     sourceService,
 		clickHouseService: clickHouseService,
 		maxSize:           maxSize,
+		logger:            logger,
 	}
 }
 
@@ -55,47 +56,47 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("Received file upload: %s (size: %d bytes)\n", file.Filename, file.Size)
-
-	// Create uploads directory if it doesn't exist
-	if err := os.MkdirAll("uploads", 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
 			Success: false,
-			Error:   "Failed to create uploads directory",
+			Error:   "Failed to read uploaded file",
 		})
 		return
 	}
+	defer src.Close()
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	filePath := filepath.Join("uploads", filename)
-
-	// Save the file
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{
+	upload, err := h.service.SaveUpload(src, file.Filename, principalName(c), h.maxSize)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUploadTooLarge) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.Response{
 			Success: false,
-			Error:   "Failed to save file",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	fmt.Printf("File saved successfully: %s\n", filePath)
+	requestLogger(c, h.logger).Info("file uploaded", "upload_id", upload.ID, "bytes", file.Size)
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
-		Data:    map[string]string{"filePath": filePath},
+		Data:    upload,
 	})
 }
 
 func (h *FileHandler) GetColumns(c *gin.Context) {
-	filePath := c.Query("filePath")
+	filePath, ok := h.uploadPath(c, c.Query("uploadId"))
+	if !ok {
+		return
+	}
 	delimiter := c.Query("delimiter")
 	if delimiter == "" {
 		delimiter = ","
 	}
 
-	fmt.Printf("Reading columns from file: %s (delimiter: %s)\n", filePath, delimiter)
-
 	file, err := os.Open(filePath)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{
@@ -119,8 +120,6 @@ func (h *FileHandler) GetColumns(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("Found %d columns in file: %v\n", len(headers), headers)
-
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
 		Data:    headers,
@@ -128,7 +127,10 @@ func (h *FileHandler) GetColumns(c *gin.Context) {
 }
 
 func (h *FileHandler) GetPreview(c *gin.Context) {
-	filePath := c.Query("filePath")
+	filePath, ok := h.uploadPath(c, c.Query("uploadId"))
+	if !ok {
+		return
+	}
 	delimiter := c.Query("delimiter")
 	limit := c.DefaultQuery("limit", "100")
 	if delimiter == "" {
@@ -140,8 +142,6 @@ func (h *FileHandler) GetPreview(c *gin.Context) {
 		limitInt = 100
 	}
 
-	fmt.Printf("Generating preview for file: %s (delimiter: %s, limit: %d)\n", filePath, delimiter, limitInt)
-
 	file, err := os.Open(filePath)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{
@@ -159,8 +159,7 @@ func (h *FileHandler) GetPreview(c *gin.Context) {
 	rowCount := 0
 
 	// Read header
-	headers, err := reader.Read()
-	if err != nil {
+	if _, err := reader.Read(); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{
 			Success: false,
 			Error:   "Failed to read file header",
@@ -168,8 +167,6 @@ func (h *FileHandler) GetPreview(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("Found headers: %v\n", headers)
-
 	for rowCount < limitInt {
 		row, err := reader.Read()
 		if err == io.EOF {
@@ -184,34 +181,56 @@ func (h *FileHandler) GetPreview(c *gin.Context) {
 		}
 		data = append(data, row)
 		rowCount++
-		if rowCount%1000 == 0 {
-			fmt.Printf("Processed %d rows\n", rowCount)
-		}
 	}
 
-	fmt.Printf("Generated preview with %d rows\n", len(data))
-	if len(data) > 0 {
-		fmt.Printf("First row sample: %v\n", data[0])
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
+func (h *FileHandler) GetProfile(c *gin.Context) {
+	filePath, ok := h.uploadPath(c, c.Query("uploadId"))
+	if !ok {
+		return
+	}
+	delimiter := c.Query("delimiter")
+	if delimiter == "" {
+		delimiter = ","
+	}
+	topN, _ := strconv.Atoi(c.Query("top"))
+
+	profile, err := h.service.ProfileFile(filePath, rune(delimiter[0]), topN)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
-		Data:    data,
+		Data:    profile,
 	})
 }
 
 func (h *FileHandler) Cleanup(c *gin.Context) {
-	filePath := c.Query("filePath")
-	if filePath == "" {
+	uploadID := c.Query("uploadId")
+	if uploadID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   "File path is required",
+			"error":   "Upload ID is required",
 		})
 		return
 	}
 
-	if err := h.service.Cleanup(filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if err := h.service.DeleteUpload(uploadID, ownerScope(c)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUploadNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
@@ -224,12 +243,47 @@ func (h *FileHandler) Cleanup(c *gin.Context) {
 	})
 }
 
+func (h *FileHandler) Download(c *gin.Context) {
+	file, export, err := h.service.OpenExport(c.Param("id"), ownerScope(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrExportNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrExportExpired):
+			status = http.StatusGone
+		}
+		c.JSON(status, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	contentType := "text/csv; charset=utf-8"
+	if filepath.Ext(export.Name) == ".tsv" {
+		contentType = "text/tab-separated-values; charset=utf-8"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Name))
+	c.Header("Expires", export.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// ServeContent handles Range, If-Range and If-Modified-Since for us
+	http.ServeContent(c.Writer, c.Request, export.Name, export.CreatedAt, file)
+}
+
 func (h *FileHandler) ImportFile(c *gin.Context) {
 	var req struct {
-		FilePath  string          `json:"filePath"`
-		Table     string          `json:"table"`
-		Columns   []models.Column `json:"columns"`
-		Delimiter string          `json:"delimiter"`
+		Config      models.ClickHouseConfig `json:"config"`
+		UploadID    string                  `json:"uploadId"`
+		Source      *models.ImportSource    `json:"source"`
+		Table       string                  `json:"table"`
+		Columns     []models.Column         `json:"columns"`
+		Delimiter   string                  `json:"delimiter"`
+		Workers     int                     `json:"workers"`
+		AsyncInsert *models.AsyncInsert     `json:"asyncInsert"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -240,106 +294,56 @@ func (h *FileHandler) ImportFile(c *gin.Context) {
 		return
 	}
 
+	// Authorize before reading the file so a denied import costs nothing
+	config, err := h.clickHouseService.ResolveConfig(req.Config)
+	if err != nil {
+		c.JSON(connectErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	source := req.Source
+	if source == nil {
+		source = &models.ImportSource{Type: models.SourceUpload, UploadID: req.UploadID}
+	}
+	if err := auth.Authorize(c, sourceAccess(auth.Access{
+		Direction: auth.DirectionImport,
+		Profile:   config.Profile,
+		Databases: []string{tableDatabase(config.Database, req.Table)},
+	}, *source)); err != nil {
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	if req.Delimiter == "" {
 		req.Delimiter = ","
 	}
 
-	fmt.Printf("Starting file import: %s to table %s\n", req.FilePath, req.Table)
-
-	file, err := os.Open(req.FilePath)
+	body, name, err := h.sourceService.Open(c.Request.Context(), *source, ownerScope(c))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{
+		c.JSON(sourceErrorStatus(err), models.Response{
 			Success: false,
-			Error:   "Failed to open file",
+			Error:   err.Error(),
 		})
 		return
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.Comma = rune(req.Delimiter[0])
+	defer body.Close()
 
-	// Skip header
-	if _, err := reader.Read(); err != nil {
+	rows, err := services.NewImportRowReader(body, rune(req.Delimiter[0]), req.Columns)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{
 			Success: false,
-			Error:   "Failed to read file header",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	var data [][]interface{}
-	rowCount := 0
-
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			c.JSON(http.StatusBadRequest, models.Response{
-				Success: false,
-				Error:   "Failed to read file row",
-			})
-			return
-		}
-
-		// Convert string row to interface{} slice
-		interfaceRow := make([]interface{}, len(row))
-		for i, val := range row {
-			// Try to convert to appropriate type based on column type
-			if i < len(req.Columns) {
-				switch req.Columns[i].Type {
-				case "Int32", "Int64", "UInt32", "UInt64":
-					if num, err := strconv.ParseInt(val, 10, 64); err == nil {
-						interfaceRow[i] = num
-					} else {
-						interfaceRow[i] = val
-					}
-				case "Float32", "Float64":
-					if num, err := strconv.ParseFloat(val, 64); err == nil {
-						interfaceRow[i] = num
-					} else {
-						interfaceRow[i] = val
-					}
-				case "Date", "DateTime":
-					if t, err := time.Parse("2006-01-02", val); err == nil {
-						interfaceRow[i] = t
-					} else {
-						interfaceRow[i] = val
-					}
-				default:
-					interfaceRow[i] = val
-				}
-			} else {
-				interfaceRow[i] = val
-			}
-		}
-		data = append(data, interfaceRow)
-		rowCount++
-
-		if rowCount%1000 == 0 {
-			fmt.Printf("Processed %d rows\n", rowCount)
-		}
-	}
-
-	fmt.Printf("Read %d rows from file\n", rowCount)
-
-	// Create import request
-	importReq := models.ImportRequest{
-		Table:   req.Table,
-		Columns: req.Columns,
-		Data:    data,
-	}
-
 	// Get ClickHouse connection
-	conn, err := h.clickHouseService.Connect(models.ClickHouseConfig{
-		Host:     c.GetString("clickhouse_host"),
-		Port:     c.GetInt("clickhouse_port"),
-		Database: c.GetString("clickhouse_database"),
-		User:     c.GetString("clickhouse_user"),
-		JWTToken: c.GetString("clickhouse_jwt_token"),
-	})
+	conn, err := h.clickHouseService.Connect(config)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
@@ -349,17 +353,129 @@ func (h *FileHandler) ImportFile(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	// Import data
-	if err := h.clickHouseService.ImportData(conn, importReq); err != nil {
+	// Rows stream from the file to the insert workers
+	importReq := models.ImportRequest{
+		Config:      config,
+		Table:       req.Table,
+		Columns:     req.Columns,
+		Workers:     req.Workers,
+		AsyncInsert: req.AsyncInsert,
+	}
+	logger := requestLogger(c, h.logger).With("source", logging.URL(name), "table", req.Table)
+	metrics, err := h.clickHouseService.ImportRows(conn, importReq, rows.Next)
+	if err != nil {
+		logger.Error("file import failed", "error", err)
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to import data: %v", err),
 		})
 		return
 	}
+	logImport(logger, metrics)
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    metrics,
+	})
+}
+
+// ListStoredFiles reports every upload and export kept on disk, with sizes
+// and ages, for administrators.
+func (h *FileHandler) ListStoredFiles(c *gin.Context) {
+	usage, err := h.service.ListFiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
-		Data:    map[string]int{"rows_imported": rowCount},
+		Data:    usage,
 	})
 }
+
+// uploadPath resolves an upload ID for the caller, writing the error
+// response itself when the ID is missing or not visible to the caller.
+func (h *FileHandler) uploadPath(c *gin.Context, id string) (string, bool) {
+	if id == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Upload ID is required",
+		})
+		return "", false
+	}
+
+	path, err := h.service.UploadPath(id, ownerScope(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUploadNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return "", false
+	}
+	return path, true
+}
+
+func sourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound), errors.Is(err, services.ErrSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrSourceNotAllowed):
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// principalName is the owner recorded on new uploads; it is empty when
+// auth is disabled.
+func principalName(c *gin.Context) string {
+	if principal := auth.FromContext(c); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// ownerScope is the owner uploads and jobs are checked against. Admins
+// and unauthenticated deployments see everything.
+func ownerScope(c *gin.Context) string {
+	principal := auth.FromContext(c)
+	if principal == nil || principal.IsAdmin() {
+		return ""
+	}
+	return principal.Name
+}
+
+// sourceAccess adds the configured server directory or S3 endpoint that
+// src reads from to access.
+func sourceAccess(access auth.Access, src models.ImportSource) auth.Access {
+	switch src.Type {
+	case models.SourceServer:
+		access.ServerDir = src.Dir
+	case models.SourceS3:
+		access.S3Endpoint = src.Endpoint
+	}
+	return access
+}
+
+// requestLogger returns the logger carrying the request ID, or fallback.
+func requestLogger(c *gin.Context, fallback *slog.Logger) *slog.Logger {
+	return logging.FromContext(c.Request.Context(), fallback)
+}
+
+func logImport(logger *slog.Logger, metrics *models.ImportMetrics) {
+	logger.Info("import finished",
+		"status", metrics.Status,
+		"rows", metrics.Rows,
+		"batches", metrics.Batches,
+		"workers", metrics.Workers,
+		"duration_ms", metrics.DurationMs,
+		"rows_per_second", metrics.RowsPerSecond,
+	)
+}
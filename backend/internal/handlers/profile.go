@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"clickhouse-integration/internal/models"
+	"clickhouse-integration/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProfileHandler struct {
+	store *services.ProfileStore
+}
+
+func NewProfileHandler(store *services.ProfileStore) *ProfileHandler {
+	return &ProfileHandler{store: store}
+}
+
+func (h *ProfileHandler) List(c *gin.Context) {
+	profiles, err := h.store.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    profiles,
+	})
+}
+
+func (h *ProfileHandler) Get(c *gin.Context) {
+	profile, err := h.store.GetRedacted(c.Param("name"))
+	if err != nil {
+		c.JSON(profileErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    profile,
+	})
+}
+
+func (h *ProfileHandler) Create(c *gin.Context) {
+	var profile models.ConnectionProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	created, err := h.store.Create(profile)
+	if err != nil {
+		c.JSON(profileErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "Profile created",
+		Data:    created,
+	})
+}
+
+func (h *ProfileHandler) Update(c *gin.Context) {
+	var profile models.ConnectionProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+	profile.Name = c.Param("name")
+
+	updated, err := h.store.Update(profile)
+	if err != nil {
+		c.JSON(profileErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Profile updated",
+		Data:    updated,
+	})
+}
+
+func (h *ProfileHandler) Delete(c *gin.Context) {
+	if err := h.store.Delete(c.Param("name")); err != nil {
+		c.JSON(profileErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Profile deleted",
+	})
+}
+
+func profileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrProfileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrProfileExists):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
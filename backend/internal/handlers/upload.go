@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"clickhouse-integration/internal/models"
+	"clickhouse-integration/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resumable uploads: POST /uploads starts one, PATCH /uploads/:id appends
+// a chunk at the offset given in the Upload-Offset header with its SHA-256
+// in X-Chunk-SHA256, GET /uploads/:id reports the offset to resume from and
+// POST /uploads/:id/complete verifies the file and returns its upload ID.
+
+func (h *FileHandler) InitUpload(c *gin.Context) {
+	var req models.InitUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	session, err := h.service.InitUpload(req, principalName(c))
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    session,
+	})
+}
+
+func (h *FileHandler) GetUploadSession(c *gin.Context) {
+	session, err := h.service.GetUploadSession(c.Param("id"), ownerScope(c))
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    session,
+	})
+}
+
+func (h *FileHandler) AppendChunk(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Upload-Offset header is required",
+		})
+		return
+	}
+
+	session, err := h.service.AppendChunk(c.Param("id"), ownerScope(c), offset, c.GetHeader("X-Chunk-SHA256"), c.Request.Body)
+	if err != nil {
+		var mismatch *services.OffsetMismatchError
+		if errors.As(err, &mismatch) {
+			c.Header("Upload-Offset", strconv.FormatInt(mismatch.Offset, 10))
+		}
+		c.JSON(uploadErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    session,
+	})
+}
+
+func (h *FileHandler) CompleteUpload(c *gin.Context) {
+	var req struct {
+		SHA256 string `json:"sha256"`
+	}
+	// The body is optional; the checksum may have been given at init
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid request body",
+			})
+			return
+		}
+	}
+
+	upload, err := h.service.CompleteUpload(c.Param("id"), ownerScope(c), req.SHA256)
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Upload complete",
+		Data:    upload,
+	})
+}
+
+func (h *FileHandler) AbortUpload(c *gin.Context) {
+	if err := h.service.AbortUpload(c.Param("id"), ownerScope(c)); err != nil {
+		c.JSON(uploadErrorStatus(err), models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Upload aborted",
+	})
+}
+
+func uploadErrorStatus(err error) int {
+	var mismatch *services.OffsetMismatchError
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &mismatch):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrChecksumMismatch), errors.Is(err, services.ErrUploadIncomplete):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,148 @@
+// Package logging builds the server's structured logger and carries
+// request-scoped loggers through contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Redacted replaces the value of an attribute that must not be logged.
+const Redacted = "[REDACTED]"
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// credentialKeys are substrings of attribute keys whose values are
+// secrets, e.g. password, api_key or secret_access_key.
+var credentialKeys = []string{"password", "secret", "token", "authorization", "api_key", "apikey", "credential"}
+
+// rowDataKeys are attribute keys that could carry table or file contents.
+// Numeric values such as row counts pass.
+var rowDataKeys = map[string]bool{"row": true, "rows": true, "values": true, "sample": true, "data": true}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// New builds the logger described by cfg, writing to w.
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	switch cfg.Format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", cfg.Format)
+}
+
+// redact blanks credentials and row data whatever the log call passed.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, credential := range credentialKeys {
+		if strings.Contains(key, credential) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+	if rowDataKeys[key] {
+		switch a.Value.Kind() {
+		case slog.KindInt64, slog.KindUint64, slog.KindFloat64:
+		default:
+			return slog.String(a.Key, Redacted)
+		}
+	}
+	return a
+}
+
+// URL returns a URL without user info and query string, which can hold
+// credentials such as presigned signatures. Other strings, such as file
+// names, are returned unchanged.
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	if u.User == nil && u.RawQuery == "" && u.Fragment == "" {
+		return raw
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or fallback.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// Middleware gives every request an ID, taken from a well-formed
+// X-Request-ID header or generated, and returns it in the response. The
+// request context carries a logger with the ID, and each request is logged
+// when it ends.
+func Middleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		requestLogger := logger.With("request_id", id)
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), requestLogger))
+
+		started := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		requestLogger.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(started).Milliseconds(),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
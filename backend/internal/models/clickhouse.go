@@ -1,11 +1,78 @@
 package models
 
+import "time"
+
+// ClickHouseConfig describes a connection. When Profile is set the stored
+// profile of that name supplies the connection instead, and only a
+// non-empty Database here overrides the profile's. Secure connects with
+// TLS, on the native protocol and as HTTPS. Compression applies to the
+// native protocol (none, lz4 or zstd) and HTTPCompression to raw imports
+// over HTTP (none, gzip, deflate or br); both default to the server
+// configuration. AsyncInsert is the default for imports through this
+// connection, typically set on a profile.
 type ClickHouseConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Database string `json:"database"`
-	User     string `json:"user"`
-	JWTToken string `json:"jwtToken"`
+	Profile         string       `json:"profile,omitempty"`
+	Host            string       `json:"host"`
+	Port            int          `json:"port"`
+	HTTPPort        int          `json:"httpPort,omitempty"`
+	Secure          bool         `json:"secure,omitempty"`
+	Database        string       `json:"database"`
+	User            string       `json:"user"`
+	Password        string       `json:"password,omitempty"`
+	Compression     string       `json:"compression,omitempty"`
+	HTTPCompression string       `json:"httpCompression,omitempty"`
+	AsyncInsert     *AsyncInsert `json:"asyncInsert,omitempty"`
+}
+
+// AsyncInsert sends imports with ClickHouse's async_insert, which buffers
+// many small inserts on the server into fewer parts. With Wait each insert
+// returns once its data is flushed to the table; without it, as soon as
+// the server has accepted the data into the buffer.
+type AsyncInsert struct {
+	Enabled bool `json:"enabled"`
+	Wait    bool `json:"wait"`
+}
+
+// WireStats compares the bytes a job moved over the network with their
+// uncompressed size. RawBytes is 0 when the uncompressed size is unknown.
+type WireStats struct {
+	Compression string  `json:"compression"`
+	RawBytes    uint64  `json:"rawBytes"`
+	WireBytes   uint64  `json:"wireBytes"`
+	Ratio       float64 `json:"ratio,omitempty"`
+}
+
+// ConnectionProfile is a named, server-side stored ClickHouseConfig.
+// Profiles returned by the API never include the password; HasSecrets
+// reports whether one is stored. ClearSecrets on an update drops the
+// stored password instead of keeping it.
+type ConnectionProfile struct {
+	Name         string           `json:"name"`
+	Config       ClickHouseConfig `json:"config"`
+	HasSecrets   bool             `json:"hasSecrets"`
+	ClearSecrets bool             `json:"clearSecrets,omitempty"`
+	CreatedAt    time.Time        `json:"createdAt"`
+	UpdatedAt    time.Time        `json:"updatedAt"`
+}
+
+type DatabaseInfo struct {
+	Name   string `json:"name"`
+	Engine string `json:"engine"`
+}
+
+const (
+	TableTypeTable            = "table"
+	TableTypeView             = "view"
+	TableTypeMaterializedView = "materialized_view"
+	TableTypeDictionary       = "dictionary"
+)
+
+// TableSummary is an entry in a database listing. Type is one of the
+// TableType constants.
+type TableSummary struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Engine string `json:"engine"`
 }
 
 type TableInfo struct {
@@ -17,21 +84,333 @@ type Column struct {
 	Name     string `json:"name"`
 	Type     string `json:"type"`
 	Nullable bool   `json:"nullable"`
+
+	// Populated when the column is read from system.columns
+	DefaultKind       string    `json:"defaultKind,omitempty"`
+	DefaultExpression string    `json:"defaultExpression,omitempty"`
+	Comment           string    `json:"comment,omitempty"`
+	Codec             string    `json:"codec,omitempty"`
+	InPrimaryKey      bool      `json:"inPrimaryKey,omitempty"`
+	InSortingKey      bool      `json:"inSortingKey,omitempty"`
+	InPartitionKey    bool      `json:"inPartitionKey,omitempty"`
+	TypeInfo          *TypeInfo `json:"typeInfo,omitempty"`
+}
+
+// TypeInfo is the parsed structure of a ClickHouse type. Element is set
+// for Array, Key and Value for Map, Fields for Tuple and Nested; Params
+// holds the arguments of other parameterised types such as Decimal(P, S)
+// or DateTime64(3, 'UTC').
+type TypeInfo struct {
+	Name           string      `json:"name"`
+	Params         []string    `json:"params,omitempty"`
+	Nullable       bool        `json:"nullable,omitempty"`
+	LowCardinality bool        `json:"lowCardinality,omitempty"`
+	Element        *TypeInfo   `json:"element,omitempty"`
+	Key            *TypeInfo   `json:"key,omitempty"`
+	Value          *TypeInfo   `json:"value,omitempty"`
+	Fields         []TypeField `json:"fields,omitempty"`
+}
+
+// TypeField is an element of a Tuple; Name is empty for unnamed tuples.
+type TypeField struct {
+	Name string   `json:"name,omitempty"`
+	Type TypeInfo `json:"type"`
 }
 
 type ExportRequest struct {
+	Config    ClickHouseConfig `json:"config"`
+	Table     string           `json:"table"`
+	Columns   []string         `json:"columns"`
+	Query     string           `json:"query,omitempty"`
+	Output    string           `json:"output,omitempty"`
+	Delimiter string           `json:"delimiter,omitempty"`
+	Format    ExportFormat     `json:"format,omitempty"`
+
+	// Structured filtering, only valid when Query is empty
+	Filters     []ExportFilter     `json:"filters,omitempty"`
+	OrderBy     []ExportOrder      `json:"orderBy,omitempty"`
+	Limit       uint64             `json:"limit,omitempty"`
+	Offset      uint64             `json:"offset,omitempty"`
+	Sample      float64            `json:"sample,omitempty"`
+	Incremental *IncrementalExport `json:"incremental,omitempty"`
+
+	// Destination and PartitionBy apply to the s3 output
+	Destination *ExportDestination `json:"destination,omitempty"`
+	PartitionBy string             `json:"partitionBy,omitempty"`
+
+	Parallel *ParallelExport `json:"parallel,omitempty"`
+}
+
+// Ways a parallel export splits a table.
+const (
+	SplitPartition = "partition"
+	SplitKeyRange  = "key_range"
+	SplitHash      = "hash"
+)
+
+// ParallelExport reads a table in slices that run concurrently, each on
+// its own connection. SplitBy is one of the Split constants. Key is the
+// column for key_range and hash splits and defaults to the first primary
+// key column; Slices defaults to the concurrency and is capped by the
+// server's max_export_slices. Concurrency is capped by the server's
+// export_workers. With PerSlice and the file output every
+// slice is written to its own file; otherwise the slices are merged in
+// slice order.
+type ParallelExport struct {
+	SplitBy     string `json:"splitBy"`
+	Key         string `json:"key,omitempty"`
+	Slices      int    `json:"slices,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	PerSlice    bool   `json:"perSlice,omitempty"`
+}
+
+// ExportSlice reports one slice of a parallel export.
+type ExportSlice struct {
+	Index      int    `json:"index"`
+	Label      string `json:"label"`
+	Rows       int    `json:"rows"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// ExportDestination is a location in a configured S3 endpoint. Objects and
+// the manifest are written under Prefix.
+type ExportDestination struct {
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix"`
+}
+
+// ExportFilter is a single predicate on a column. Op is one of =, !=, <,
+// <=, >, >=, in, not_in, like, not_like, is_null and is_not_null; Value
+// must be a list for in and not_in and is ignored for the null checks.
+type ExportFilter struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+type ExportOrder struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// IncrementalExport exports only rows whose Column is greater than the
+// value recorded by the previous run with the same Key. Key names a
+// watermark within the export's profile or server, database and table, and
+// defaults to the column. Column must be strictly increasing, such
+// as a sequence number: a row inserted later with a value already passed,
+// including one equal to the watermark, is never exported. Exports fail
+// when a run reads the same value twice.
+type IncrementalExport struct {
+	Column string `json:"column"`
+	Key    string `json:"key,omitempty"`
+}
+
+// Watermark is the last exported value of an incremental export's column.
+type Watermark struct {
+	Key    string `json:"key"`
+	Column string `json:"column"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+}
+
+// ExportFormat controls how values are rendered in file exports. Empty
+// layouts and Timezone fall back to ClickHouse's own text representation.
+// NULL is written as NullValue, which defaults to an empty field rather
+// than ClickHouse's \N, matching how imports read empty Nullable cells.
+type ExportFormat struct {
+	DateLayout       string `json:"dateLayout,omitempty"`
+	DateTimeLayout   string `json:"dateTimeLayout,omitempty"`
+	Timezone         string `json:"timezone,omitempty"`
+	NullValue        string `json:"nullValue,omitempty"`
+	TrimDecimalZeros bool   `json:"trimDecimalZeros,omitempty"`
+}
+
+// ExportOutputFile makes the export endpoint write a downloadable file
+// instead of returning rows in the response body; ExportOutputS3 writes
+// objects to the request's Destination.
+const (
+	ExportOutputFile = "file"
+	ExportOutputS3   = "s3"
+)
+
+// ExportResult carries the scanned rows together with the result set's
+// column names and ClickHouse types.
+type ExportResult struct {
+	Columns   []Column        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Watermark *Watermark      `json:"watermark,omitempty"`
+	Slices    []ExportSlice   `json:"slices,omitempty"`
+	Wire      *WireStats      `json:"wire,omitempty"`
+}
+
+type ExportFileResult struct {
+	FileID      string     `json:"fileId"`
+	DownloadURL string     `json:"downloadUrl"`
+	Rows        int        `json:"rows"`
+	Size        int64      `json:"size"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	Watermark   *Watermark `json:"watermark,omitempty"`
+	Slice       string     `json:"slice,omitempty"`
+	Wire        *WireStats `json:"wire,omitempty"`
+}
+
+// ExportSliceFiles is the result of a parallel export with one file per
+// slice, in slice order.
+type ExportSliceFiles struct {
+	Files  []ExportFileResult `json:"files"`
+	Slices []ExportSlice      `json:"slices"`
+}
+
+// ExportManifest lists the objects an S3 export wrote. It is stored next
+// to them as manifest.json and returned to the client.
+type ExportManifest struct {
+	Bucket      string           `json:"bucket"`
+	Prefix      string           `json:"prefix"`
+	Columns     []Column         `json:"columns"`
+	PartitionBy string           `json:"partitionBy,omitempty"`
+	Objects     []ManifestObject `json:"objects"`
+	Rows        int              `json:"rows"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+type ManifestObject struct {
+	Key       string `json:"key"`
+	Partition string `json:"partition,omitempty"`
+	Rows      int    `json:"rows"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+type ExportS3Result struct {
+	ManifestKey string          `json:"manifestKey"`
+	Manifest    *ExportManifest `json:"manifest"`
+	Watermark   *Watermark      `json:"watermark,omitempty"`
+	Wire        *WireStats      `json:"wire,omitempty"`
+}
+
+// PreviewRequest asks for one page of a table or of a custom SELECT.
+// Cursor is the NextCursor of the previous page. It is an opaque offset,
+// so pages may overlap or skip rows when the data or ordering is not
+// stable; use an export to read every row once.
+type PreviewRequest struct {
 	Config  ClickHouseConfig `json:"config"`
-	Table   string          `json:"table"`
-	Columns []string        `json:"columns"`
-	Query   string          `json:"query,omitempty"`
+	Table   string           `json:"table,omitempty"`
+	Columns []string         `json:"columns,omitempty"`
+	Query   string           `json:"query,omitempty"`
+	Limit   int              `json:"limit,omitempty"`
+	Cursor  string           `json:"cursor,omitempty"`
+}
+
+type PreviewResult struct {
+	Columns         []Column        `json:"columns"`
+	Rows            [][]interface{} `json:"rows"`
+	NextCursor      string          `json:"nextCursor,omitempty"`
+	ApproxTotalRows *uint64         `json:"approxTotalRows,omitempty"`
+}
+
+// TableStats describes a table's storage from system.tables and
+// system.parts, together with a profile of each column.
+type TableStats struct {
+	Database          string          `json:"database"`
+	Table             string          `json:"table"`
+	Engine            string          `json:"engine"`
+	PartitionKey      string          `json:"partitionKey"`
+	SortingKey        string          `json:"sortingKey"`
+	PrimaryKey        string          `json:"primaryKey"`
+	Rows              uint64          `json:"rows"`
+	Parts             uint64          `json:"parts"`
+	CompressedBytes   uint64          `json:"compressedBytes"`
+	UncompressedBytes uint64          `json:"uncompressedBytes"`
+	Columns           []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile summarises the values of one column. Min, Max and the top
+// values are rendered as text so every column type fits the same shape.
+type ColumnProfile struct {
+	Name             string       `json:"name"`
+	Type             string       `json:"type"`
+	NullCount        uint64       `json:"nullCount"`
+	DistinctEstimate uint64       `json:"distinctEstimate"`
+	Min              string       `json:"min,omitempty"`
+	Max              string       `json:"max,omitempty"`
+	TopValues        []ValueCount `json:"topValues,omitempty"`
+	Error            string       `json:"error,omitempty"`
+}
+
+type ValueCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
 }
 
+// FileProfile is the column profile of an uploaded file.
+type FileProfile struct {
+	Rows    uint64          `json:"rows"`
+	Columns []ColumnProfile `json:"columns"`
+}
+
+// ImportRequest inserts rows through the native protocol. Workers caps the
+// number of parallel insert connections below the server's insert_workers;
+// 1 keeps the rows in file order. AsyncInsert overrides the connection's.
 type ImportRequest struct {
-	Config    ClickHouseConfig `json:"config"`
-	Table     string          `json:"table"`
-	Columns   []Column        `json:"columns"`
-	Data      [][]interface{} `json:"data"`
-	Delimiter string          `json:"delimiter"`
+	Config      ClickHouseConfig `json:"config"`
+	Table       string           `json:"table"`
+	Columns     []Column         `json:"columns"`
+	Data        [][]interface{}  `json:"data"`
+	Delimiter   string           `json:"delimiter"`
+	Workers     int              `json:"workers,omitempty"`
+	AsyncInsert *AsyncInsert     `json:"asyncInsert,omitempty"`
+}
+
+// Import statuses. An accepted import sits in the server's async insert
+// buffer and may not be visible in the table yet.
+const (
+	ImportFlushed  = "flushed"
+	ImportAccepted = "accepted"
+)
+
+// ImportMetrics reports how an import went. Columnar is false when a target
+// column type has no typed builder and rows were appended one by one.
+// Status is one of the Import statuses; TableRows may not count accepted
+// rows yet.
+type ImportMetrics struct {
+	Status        string     `json:"status"`
+	Rows          uint64     `json:"rows"`
+	Batches       int        `json:"batches"`
+	Workers       int        `json:"workers"`
+	Columnar      bool       `json:"columnar"`
+	DurationMs    int64      `json:"durationMs"`
+	RowsPerSecond float64    `json:"rowsPerSecond"`
+	TableRows     uint64     `json:"tableRows"`
+	Wire          *WireStats `json:"wire,omitempty"`
+}
+
+// CopyRequest copies a table between two ClickHouse servers, databases or
+// tables. TargetTable defaults to SourceTable and Columns to all columns.
+// With UseRemote the target server pulls the rows itself through remote(),
+// which requires it to reach the source's native port, and the source
+// needs its own user and password unless the target is the default server.
+type CopyRequest struct {
+	Source      ClickHouseConfig `json:"source"`
+	Target      ClickHouseConfig `json:"target"`
+	SourceTable string           `json:"sourceTable"`
+	TargetTable string           `json:"targetTable,omitempty"`
+	Columns     []string         `json:"columns,omitempty"`
+	CreateTable bool             `json:"createTable,omitempty"`
+	BatchSize   int              `json:"batchSize,omitempty"`
+	UseRemote   bool             `json:"useRemote,omitempty"`
+}
+
+const (
+	CopyModeStream = "stream"
+	CopyModeRemote = "remote"
+)
+
+type CopyResult struct {
+	Mode         string `json:"mode"`
+	RowsCopied   uint64 `json:"rowsCopied"`
+	Batches      int    `json:"batches,omitempty"`
+	TableCreated bool   `json:"tableCreated"`
 }
 
 type Response struct {
@@ -40,4 +419,140 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
 }
-	
\ No newline at end of file
+
+// Upload is the metadata record of an uploaded file. Clients refer to
+// uploads only by ID; the storage path never leaves the server.
+type Upload struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const (
+	StoredFileUpload = "upload"
+	StoredFileExport = "export"
+	// StoredFileOrphan is a file in the upload directory with no metadata
+	// record, such as uploads saved before upload IDs existed.
+	StoredFileOrphan = "orphan"
+	// StoredFilePartial is an unfinished resumable upload.
+	StoredFilePartial = "partial"
+)
+
+type StoredFile struct {
+	Kind       string    `json:"kind"`
+	ID         string    `json:"id,omitempty"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Owner      string    `json:"owner,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	AgeSeconds int64     `json:"ageSeconds"`
+}
+
+type StorageUsage struct {
+	Files        []StoredFile `json:"files"`
+	TotalSize    int64        `json:"totalSize"`
+	MaxDiskUsage int64        `json:"maxDiskUsage,omitempty"`
+}
+
+// UploadSession tracks a resumable upload. Offset is the number of bytes
+// committed so far; an interrupted client resumes by sending the next chunk
+// at that offset.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Offset    int64     `json:"offset"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// InitUploadRequest starts a resumable upload. Size and SHA256 are
+// optional and, when given, are verified on completion.
+type InitUploadRequest struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+const (
+	SourceUpload = "upload"
+	SourceServer = "server"
+	SourceURL    = "url"
+	SourceS3     = "s3"
+	// SourceClickHouseFile is a file in the ClickHouse server's
+	// user_files directory, read with the file() table function.
+	SourceClickHouseFile = "clickhouse_file"
+)
+
+// ImportSource names where an import reads its file from. Type defaults to
+// an upload. Server sources name a configured directory in Dir, S3 sources
+// a configured endpoint in Endpoint.
+type ImportSource struct {
+	Type     string `json:"type"`
+	UploadID string `json:"uploadId,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+// IngestRequest loads a file that ClickHouse reads itself through the
+// s3(), url() or file() table function, so rows never pass through this
+// server. Format defaults to CSVWithNames.
+type IngestRequest struct {
+	Config    ClickHouseConfig `json:"config"`
+	Table     string           `json:"table"`
+	Columns   []Column         `json:"columns"`
+	Source    ImportSource     `json:"source"`
+	Format    string           `json:"format"`
+	Delimiter string           `json:"delimiter"`
+}
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// IngestJob reports the progress of a server-side ingestion. Counters are
+// updated from the query progress packets while the INSERT runs. For raw
+// imports over HTTP, ReadBytes counts the data before and WireBytes after
+// Compression.
+type IngestJob struct {
+	ID              string     `json:"id"`
+	QueryID         string     `json:"queryId"`
+	Status          string     `json:"status"`
+	Table           string     `json:"table"`
+	Source          string     `json:"source"`
+	ReadRows        uint64     `json:"readRows"`
+	ReadBytes       uint64     `json:"readBytes"`
+	TotalRowsToRead uint64     `json:"totalRowsToRead,omitempty"`
+	WrittenRows     uint64     `json:"writtenRows"`
+	WrittenBytes    uint64     `json:"writtenBytes"`
+	Compression     string     `json:"compression,omitempty"`
+	WireBytes       uint64     `json:"wireBytes,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Owner           string     `json:"-"`
+	StartedAt       time.Time  `json:"startedAt"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RawImportRequest streams a file to ClickHouse over the HTTP interface as
+// INSERT ... FORMAT Format, leaving parsing to the server. Columns is
+// optional; with a *WithNames format the header maps the columns.
+type RawImportRequest struct {
+	Config    ClickHouseConfig `json:"config"`
+	Table     string           `json:"table"`
+	Columns   []string         `json:"columns,omitempty"`
+	Source    ImportSource     `json:"source"`
+	Format    string           `json:"format"`
+	Delimiter string           `json:"delimiter,omitempty"`
+}
@@ -2,47 +2,127 @@ package services
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"clickhouse-integration/internal/config"
 	"clickhouse-integration/internal/models"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
-type ClickHouseService struct{}
+// ErrCredentialsRequired rejects a connection to a server other than the
+// default one that names no user.
+var ErrCredentialsRequired = errors.New("user and password are required")
+
+type ClickHouseService struct {
+	// Limits are enforced on every export query, which always runs with
+	// readonly=1.
+	Limits     QueryLimits
+	Watermarks *WatermarkStore
+	Profiles   *ProfileStore
+
+	config config.ClickHouseConfig
+	logger *slog.Logger
+	// ingestJobs holds *ingestJob by ID for progress polling.
+	ingestJobs sync.Map
+}
 
-func NewClickHouseService() *ClickHouseService {
-	return &ClickHouseService{}
+func NewClickHouseService(cfg config.ClickHouseConfig, watermarks *WatermarkStore, profiles *ProfileStore, logger *slog.Logger) *ClickHouseService {
+	return &ClickHouseService{
+		Limits:     QueryLimits(cfg.QueryLimits),
+		Watermarks: watermarks,
+		Profiles:   profiles,
+		config:     cfg,
+		logger:     logger,
+	}
 }
 
-func (s *ClickHouseService) Connect(config models.ClickHouseConfig) (driver.Conn, error) {
-	// For Docker, use native protocol by default (port 9000)
+// ResolveConfig replaces a config that names a profile with the stored
+// profile, keeping an explicit Database override, and fills the fields the
+// request leaves empty from the server defaults.
+func (s *ClickHouseService) ResolveConfig(config models.ClickHouseConfig) (models.ClickHouseConfig, error) {
+	if config.Profile != "" {
+		profile, err := s.Profiles.Get(config.Profile)
+		if err != nil {
+			return config, err
+		}
+
+		resolved := profile.Config
+		resolved.Profile = config.Profile
+		if config.Database != "" {
+			resolved.Database = config.Database
+		}
+		config = resolved
+	}
+
+	if config.Host == "" {
+		config.Host = s.config.Host
+	}
 	if config.Port == 0 {
-		config.Port = 9000 // Default native protocol port
+		config.Port = s.config.Port
 	}
+	if config.HTTPPort == 0 {
+		config.HTTPPort = s.config.HTTPPort
+	}
+	if config.Database == "" {
+		config.Database = s.config.Database
+	}
+	if config.Compression == "" {
+		config.Compression = s.config.Compression
+	}
+	if config.HTTPCompression == "" {
+		config.HTTPCompression = s.config.HTTPCompression
+	}
+	return config, nil
+}
 
-	// Force IPv4 address
-	host := config.Host
-	if host == "localhost" || host == "::1" {
-		host = "127.0.0.1"
+func (s *ClickHouseService) Connect(config models.ClickHouseConfig) (driver.Conn, error) {
+	config, err := s.ResolveConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	method, ok := nativeCompression[config.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q, expected none, lz4 or zstd", config.Compression)
+	}
+
+	// Driver tracing includes bound values, so it only runs when the
+	// logger keeps debug records
+	driverLogger := s.logger.With("component", "clickhouse-go")
+	auth, err := s.auth(config)
+	if err != nil {
+		return nil, err
 	}
 
 	opts := &clickhouse.Options{
-		Addr: []string{fmt.Sprintf("%s:%d", host, config.Port)},
-		Auth: clickhouse.Auth{
-			Database: config.Database,
-			Username: "default",
-			Password: "password", // Use the password from docker-compose.yml
+		Addr:  []string{fmt.Sprintf("%s:%d", dialHost(config.Host), config.Port)},
+		Auth:  auth,
+		Debug: s.config.Debug && driverLogger.Enabled(context.Background(), slog.LevelDebug),
+		Debugf: func(format string, v ...any) {
+			driverLogger.Debug(fmt.Sprintf(format, v...))
 		},
-		Debug: true,
+		DialTimeout: s.config.DialTimeout,
+		Compression: &clickhouse.Compression{Method: method},
 		Settings: map[string]interface{}{
-			"max_execution_time": 60,
+			"max_execution_time": s.config.MaxExecutionTime,
 		},
 	}
 
-	conn, err := clickhouse.Open(opts)
+	if config.Secure {
+		opts.TLS = &tls.Config{ServerName: config.Host}
+	}
+
+	conn, err := openCounted(opts, config.Compression)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to ClickHouse: %v", err)
 	}
@@ -54,43 +134,140 @@ func (s *ClickHouseService) Connect(config models.ClickHouseConfig) (driver.Conn
 	return conn, nil
 }
 
-func (s *ClickHouseService) GetTables(conn driver.Conn, database string) ([]string, error) {
-	fmt.Printf("Querying tables for database: %s\n", database)
-	query := fmt.Sprintf("SELECT name FROM system.tables WHERE database = '%s'", database)
-	fmt.Printf("Executing query: %s\n", query)
+// dialHost forces the IPv4 loopback address for local servers.
+func dialHost(host string) string {
+	if host == "localhost" || host == "::1" {
+		return "127.0.0.1"
+	}
+	return host
+}
+
+// auth returns the credentials for a resolved config. A config with a user
+// or password connects with exactly those. Otherwise the server's default
+// account is used, but only for the default server, so its password is
+// never sent to another host.
+func (s *ClickHouseService) auth(config models.ClickHouseConfig) (clickhouse.Auth, error) {
+	auth := clickhouse.Auth{
+		Database: config.Database,
+		Username: config.User,
+		Password: config.Password,
+	}
+	if config.User != "" || config.Password != "" {
+		return auth, nil
+	}
+	if !s.isDefaultServer(config) {
+		return auth, fmt.Errorf("%w for %s:%d", ErrCredentialsRequired, config.Host, config.Port)
+	}
+	auth.Username = s.config.User
+	auth.Password = s.config.Password
+	return auth, nil
+}
 
-	rows, err := conn.Query(context.Background(), query)
+// isDefaultServer reports whether a resolved config points at the server
+// this service is configured for.
+func (s *ClickHouseService) isDefaultServer(config models.ClickHouseConfig) bool {
+	return dialHost(config.Host) == dialHost(s.config.Host) && config.Port == s.config.Port
+}
+
+func (s *ClickHouseService) GetDatabases(conn driver.Conn) ([]models.DatabaseInfo, error) {
+	rows, err := conn.Query(context.Background(), "SELECT name, engine FROM system.databases ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query databases: %v", err)
+	}
+	defer rows.Close()
+
+	var databases []models.DatabaseInfo
+	for rows.Next() {
+		var db models.DatabaseInfo
+		if err := rows.Scan(&db.Name, &db.Engine); err != nil {
+			return nil, fmt.Errorf("failed to scan database: %v", err)
+		}
+		databases = append(databases, db)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating databases: %v", err)
+	}
+
+	return databases, nil
+}
+
+// GetTables lists the tables, views and dictionaries of a database.
+// Dictionaries defined in server config rather than DDL only appear in
+// system.dictionaries, so they are merged in from there.
+func (s *ClickHouseService) GetTables(conn driver.Conn, database string) ([]models.TableSummary, error) {
+	rows, err := conn.Query(context.Background(),
+		"SELECT name, engine FROM system.tables WHERE database = ? ORDER BY name", database)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query tables: %v", err)
 	}
 	defer rows.Close()
 
-	var tables []string
+	var tables []models.TableSummary
+	seen := make(map[string]bool)
 	for rows.Next() {
-		var table string
-		if err := rows.Scan(&table); err != nil {
+		var table models.TableSummary
+		if err := rows.Scan(&table.Name, &table.Engine); err != nil {
 			return nil, fmt.Errorf("failed to scan table name: %v", err)
 		}
+		table.Type = tableType(table.Engine)
 		tables = append(tables, table)
-		fmt.Printf("Found table: %s\n", table)
+		seen[table.Name] = true
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating tables: %v", err)
 	}
 
-	fmt.Printf("Total tables found: %d\n", len(tables))
+	dictRows, err := conn.Query(context.Background(),
+		"SELECT name FROM system.dictionaries WHERE database = ? ORDER BY name", database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dictionaries: %v", err)
+	}
+	defer dictRows.Close()
+
+	for dictRows.Next() {
+		var name string
+		if err := dictRows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan dictionary name: %v", err)
+		}
+		if !seen[name] {
+			tables = append(tables, models.TableSummary{Name: name, Type: models.TableTypeDictionary, Engine: "Dictionary"})
+		}
+	}
+
+	if err := dictRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dictionaries: %v", err)
+	}
+
 	return tables, nil
 }
 
+func tableType(engine string) string {
+	switch engine {
+	case "View", "LiveView", "WindowView":
+		return models.TableTypeView
+	case "MaterializedView":
+		return models.TableTypeMaterializedView
+	case "Dictionary":
+		return models.TableTypeDictionary
+	}
+	return models.TableTypeTable
+}
+
+// GetColumns reads the columns of a table from system.columns in
+// declaration order. Nullable comes from the parsed type, so it is also
+// set for LowCardinality(Nullable(T)).
 func (s *ClickHouseService) GetColumns(conn driver.Conn, database, table string) ([]models.Column, error) {
-	query := fmt.Sprintf(`
-		SELECT name, type, is_nullable
+	database, table = splitTableName(database, table)
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT name, type, default_kind, default_expression, comment, compression_codec,
+			is_in_primary_key, is_in_sorting_key, is_in_partition_key
 		FROM system.columns
-		WHERE database = '%s' AND table = '%s'
+		WHERE database = ? AND table = ?
+		ORDER BY position
 	`, database, table)
-
-	rows, err := conn.Query(context.Background(), query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query columns: %v", err)
 	}
@@ -99,142 +276,276 @@ func (s *ClickHouseService) GetColumns(conn driver.Conn, database, table string)
 	var columns []models.Column
 	for rows.Next() {
 		var col models.Column
-		var isNullable string
-		if err := rows.Scan(&col.Name, &col.Type, &isNullable); err != nil {
+		var inPrimaryKey, inSortingKey, inPartitionKey uint8
+		if err := rows.Scan(&col.Name, &col.Type, &col.DefaultKind, &col.DefaultExpression, &col.Comment, &col.Codec,
+			&inPrimaryKey, &inSortingKey, &inPartitionKey); err != nil {
 			return nil, fmt.Errorf("failed to scan column: %v", err)
 		}
-		col.Nullable = isNullable == "1"
+		typeInfo := ParseType(col.Type)
+		col.TypeInfo = &typeInfo
+		col.Nullable = typeInfo.Nullable
+		col.InPrimaryKey = inPrimaryKey == 1
+		col.InSortingKey = inSortingKey == 1
+		col.InPartitionKey = inPartitionKey == 1
 		columns = append(columns, col)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating columns: %v", err)
+	}
+
 	return columns, nil
 }
 
-func (s *ClickHouseService) ExportData(conn driver.Conn, req models.ExportRequest) ([][]interface{}, error) {
-	fmt.Printf("Exporting data from table: %s\n", req.Table)
-	fmt.Printf("Selected columns: %v\n", req.Columns)
+// ExportData runs the export query and scans every row using the Go types
+// the driver reports for each result column, so custom queries may return
+// any number and type of columns.
+func (s *ClickHouseService) ExportData(conn driver.Conn, req models.ExportRequest) (*models.ExportResult, error) {
+	if req.Parallel != nil {
+		return s.exportParallel(conn, req)
+	}
 
-	columns := strings.Join(req.Columns, ", ")
-	query := fmt.Sprintf("SELECT %s FROM %s", columns, req.Table)
-	if req.Query != "" {
-		query = req.Query
+	query, args, watermark, err := s.exportQuery(req)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("Executing query: %s\n", query)
+	s.logger.Debug("running export query", "table", req.Table, "query_fingerprint", queryFingerprint(query))
 
-	rows, err := conn.Query(context.Background(), query)
+	result, err := s.queryReadOnly(conn, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %v", err)
+		return nil, err
+	}
+
+	if watermark != nil {
+		if err := checkWatermarkUnique(watermark.Column, result); err != nil {
+			return nil, err
+		}
+		result.Watermark = nextWatermark(watermark, result)
+	}
+
+	return result, nil
+}
+
+// queryReadOnly runs query in the read-only sandbox and scans the full
+// result set along with its column metadata.
+//
+// The result's Wire compares the bytes received with the uncompressed size
+// of the result blocks the server reports.
+func (s *ClickHouseService) queryReadOnly(conn driver.Conn, query string, args ...interface{}) (*models.ExportResult, error) {
+	compression, _, receivedBefore := wireCounts(conn)
+	var rawBytes atomic.Uint64
+	ctx := clickhouse.Context(s.Limits.readOnlyContext(context.Background()),
+		clickhouse.WithProfileInfo(func(p *clickhouse.ProfileInfo) {
+			rawBytes.Add(p.Bytes)
+		}),
+	)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", s.Limits.wrapLimitError(err))
 	}
 	defer rows.Close()
 
-	var results [][]interface{}
-	rowCount := 0
-	for rows.Next() {
-		row := make([]interface{}, len(req.Columns))
-		rowPtrs := make([]interface{}, len(req.Columns))
-		for i := range row {
-			rowPtrs[i] = &row[i]
+	columnTypes := rows.ColumnTypes()
+	result := &models.ExportResult{
+		Columns: make([]models.Column, len(columnTypes)),
+		Rows:    [][]interface{}{},
+	}
+	for i, ct := range columnTypes {
+		result.Columns[i] = models.Column{
+			Name:     ct.Name(),
+			Type:     ct.DatabaseTypeName(),
+			Nullable: ct.Nullable(),
 		}
-		if err := rows.Scan(rowPtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+	}
+
+	for rows.Next() {
+		row, err := scanRow(rows, columnTypes)
+		if err != nil {
+			return nil, err
 		}
-		results = append(results, row)
-		rowCount++
+		result.Rows = append(result.Rows, row)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %v", err)
+		return nil, fmt.Errorf("error iterating rows: %w", s.Limits.wrapLimitError(err))
 	}
 
-	fmt.Printf("Total rows exported: %d\n", rowCount)
-	return results, nil
+	if compression != "" {
+		_, _, receivedAfter := wireCounts(conn)
+		result.Wire = newWireStats(compression, rawBytes.Load(), receivedAfter-receivedBefore)
+	}
+	return result, nil
 }
 
-func (s *ClickHouseService) CreateTable(conn driver.Conn, tableName string, columns []models.Column) error {
-	// Build column definitions
-	var columnDefs []string
-	for _, col := range columns {
-		nullable := ""
-		if col.Nullable {
-			nullable = "Nullable"
+// exportQuery returns the SQL and bound arguments for an export. For
+// incremental exports it also returns the watermark the query starts from;
+// its Value is empty on the first run.
+func (s *ClickHouseService) exportQuery(req models.ExportRequest) (string, []interface{}, *models.Watermark, error) {
+	if req.Query != "" {
+		if len(req.Filters) > 0 || len(req.OrderBy) > 0 || req.Limit > 0 || req.Offset > 0 || req.Sample > 0 || req.Incremental != nil {
+			return "", nil, nil, fmt.Errorf("filters, ordering, limits and incremental mode cannot be combined with a custom query")
 		}
-		columnDefs = append(columnDefs, fmt.Sprintf("%s %s(%s)", col.Name, nullable, col.Type))
+		if err := ValidateReadOnlyQuery(req.Query); err != nil {
+			return "", nil, nil, err
+		}
+		return req.Query, nil, nil, nil
 	}
 
-	// Create table query
-	query := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			%s
-		) ENGINE = MergeTree()
-		ORDER BY tuple()
-	`, tableName, strings.Join(columnDefs, ",\n"))
+	if req.Incremental == nil {
+		query, args, err := buildExportQuery(req, nil, nil)
+		return query, args, nil, err
+	}
 
-	if err := conn.Exec(context.Background(), query); err != nil {
-		return fmt.Errorf("failed to create table: %v", err)
+	if req.Incremental.Column == "" {
+		return "", nil, nil, fmt.Errorf("incremental export needs a watermark column")
+	}
+	if len(req.Columns) > 0 && !containsString(req.Columns, req.Incremental.Column) {
+		return "", nil, nil, fmt.Errorf("watermark column %s must be one of the exported columns", req.Incremental.Column)
+	}
+	if req.Incremental.Key != "" && !watermarkKeyPattern.MatchString(req.Incremental.Key) {
+		return "", nil, nil, fmt.Errorf("invalid incremental key %q", req.Incremental.Key)
+	}
+	key := watermarkKey(req.Config, req)
+	previous, err := s.Watermarks.Get(key)
+	if err != nil {
+		return "", nil, nil, err
 	}
 
-	return nil
+	query, args, err := buildExportQuery(req, previous, nil)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if previous == nil {
+		previous = &models.Watermark{Key: key, Column: req.Incremental.Column}
+	}
+	return query, args, previous, nil
 }
 
-func (s *ClickHouseService) ImportData(conn driver.Conn, req models.ImportRequest) error {
-	fmt.Printf("Starting import process for table: %s\n", req.Table)
-	fmt.Printf("Columns to import: %+v\n", req.Columns)
-	fmt.Printf("Number of rows to import: %d\n", len(req.Data))
-
-	// First, create the table if it doesn't exist
-	if err := s.CreateTable(conn, req.Table, req.Columns); err != nil {
-		return fmt.Errorf("failed to prepare table: %v", err)
+// nextWatermark takes the watermark column's value from the last exported
+// row, which is the largest since incremental exports are ordered by it.
+// With no new rows the previous watermark is kept.
+func nextWatermark(previous *models.Watermark, result *models.ExportResult) *models.Watermark {
+	index := -1
+	for i, col := range result.Columns {
+		if col.Name == previous.Column {
+			index = i
+			break
+		}
 	}
-
-	// Extract column names for the INSERT query
-	columnNames := make([]string, len(req.Columns))
-	for i, col := range req.Columns {
-		columnNames[i] = col.Name
+	if index < 0 {
+		return previous
 	}
 
-	columns := strings.Join(columnNames, ", ")
-	placeholders := strings.Repeat("?,", len(req.Columns)-1) + "?"
-
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", req.Table, columns, placeholders)
-	fmt.Printf("Preparing batch insert with query: %s\n", query)
+	formatter, _ := NewValueFormatter(models.ExportFormat{})
+	for i := len(result.Rows) - 1; i >= 0; i-- {
+		if _, ok := deref(result.Rows[i][index]); ok {
+			next := *previous
+			next.Type = result.Columns[index].Type
+			next.Value = formatter.Format(result.Rows[i][index], next.Type)
+			return &next
+		}
+	}
+	return previous
+}
 
-	batch, err := conn.PrepareBatch(context.Background(), query)
-	if err != nil {
-		return fmt.Errorf("failed to prepare batch: %v", err)
+// checkWatermarkUnique rejects an incremental export whose column repeats
+// a value. The predicate is strict, so rows sharing the last value with
+// rows inserted after the run would be skipped. Rows are ordered by the
+// column, so repeats are adjacent.
+func checkWatermarkUnique(column string, result *models.ExportResult) error {
+	index := -1
+	for i, col := range result.Columns {
+		if col.Name == column {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil
 	}
 
-	fmt.Printf("Starting to append %d rows to batch\n", len(req.Data))
-	for i, row := range req.Data {
-		if i%1000 == 0 {
-			fmt.Printf("Processing row %d\n", i)
+	formatter, _ := NewValueFormatter(models.ExportFormat{})
+	previous, seen := "", false
+	for _, row := range result.Rows {
+		if _, ok := deref(row[index]); !ok {
+			continue
 		}
-		if err := batch.Append(row...); err != nil {
-			return fmt.Errorf("failed to append row %d: %v", i, err)
+		value := formatter.Format(row[index], result.Columns[index].Type)
+		if seen && value == previous {
+			return fmt.Errorf("incremental column %s is not strictly increasing: %s appears more than once", column, value)
 		}
+		previous, seen = value, true
 	}
+	return nil
+}
 
-	fmt.Printf("Sending batch to ClickHouse\n")
-	if err := batch.Send(); err != nil {
-		return fmt.Errorf("failed to send batch: %v", err)
+// CommitWatermark records an incremental export's watermark once its
+// output has been delivered, so a failed delivery is retried next run.
+func (s *ClickHouseService) CommitWatermark(wm *models.Watermark) error {
+	if wm == nil || wm.Value == "" {
+		return nil
 	}
+	return s.Watermarks.Save(*wm)
+}
 
-	// Verify the import by counting rows
-	countQuery := fmt.Sprintf("SELECT count() FROM %s", req.Table)
-	fmt.Printf("Verifying import with query: %s\n", countQuery)
+// scanRow scans the current row into freshly allocated values of each
+// column's scan type. Nullable columns come back as typed nil pointers.
+func scanRow(rows driver.Rows, columnTypes []driver.ColumnType) ([]interface{}, error) {
+	targets := make([]interface{}, len(columnTypes))
+	for i, ct := range columnTypes {
+		targets[i] = reflect.New(ct.ScanType()).Interface()
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return nil, fmt.Errorf("failed to scan row: %v", err)
+	}
 
-	rows, err := conn.Query(context.Background(), countQuery)
-	if err != nil {
-		return fmt.Errorf("failed to verify import: %v", err)
+	row := make([]interface{}, len(targets))
+	for i, target := range targets {
+		row[i] = reflect.ValueOf(target).Elem().Interface()
 	}
-	defer rows.Close()
+	return row, nil
+}
 
-	var count uint64
-	if rows.Next() {
-		if err := rows.Scan(&count); err != nil {
-			return fmt.Errorf("failed to scan row count: %v", err)
+func (s *ClickHouseService) CreateTable(conn driver.Conn, tableName string, columns []models.Column) error {
+	// Build column definitions
+	var columnDefs []string
+	for _, col := range columns {
+		// Nullable cannot wrap Array, Map, Tuple or an already nullable type
+		colType := col.Type
+		typeInfo := ParseType(colType)
+		if col.Nullable && !typeInfo.Nullable && canBeNullable(typeInfo) {
+			if inner, ok := unwrapType(colType, "LowCardinality"); ok {
+				colType = fmt.Sprintf("LowCardinality(Nullable(%s))", inner)
+			} else {
+				colType = fmt.Sprintf("Nullable(%s)", colType)
+			}
 		}
+		columnDefs = append(columnDefs, fmt.Sprintf("%s %s", quoteIdentifier(col.Name), colType))
+	}
+
+	// Create table query
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			%s
+		) ENGINE = MergeTree()
+		ORDER BY tuple()
+	`, quoteTableName(tableName), strings.Join(columnDefs, ",\n"))
+
+	if err := conn.Exec(context.Background(), query); err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
 	}
-	fmt.Printf("Successfully imported data. Total rows in table %s: %d\n", req.Table, count)
 
 	return nil
 }
+
+// ImportData inserts the rows of req.Data; see ImportRows.
+func (s *ClickHouseService) ImportData(conn driver.Conn, req models.ImportRequest) (*models.ImportMetrics, error) {
+	next := 0
+	return s.ImportRows(conn, req, func() ([]interface{}, error) {
+		if next == len(req.Data) {
+			return nil, io.EOF
+		}
+		next++
+		return req.Data[next-1], nil
+	})
+}
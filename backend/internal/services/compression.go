@@ -0,0 +1,147 @@
+package services
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/andybalholm/brotli"
+)
+
+// nativeCompression maps the config names to the driver's block
+// compression methods.
+var nativeCompression = map[string]clickhouse.CompressionMethod{
+	"none": clickhouse.CompressionNone,
+	"lz4":  clickhouse.CompressionLZ4,
+	"zstd": clickhouse.CompressionZSTD,
+}
+
+// countedConn is a connection that counts the bytes its sockets move, so
+// jobs can report them next to the uncompressed size.
+type countedConn struct {
+	driver.Conn
+	compression string
+	sent        atomic.Uint64
+	received    atomic.Uint64
+}
+
+// countingNetConn adds a socket's traffic to its countedConn.
+type countingNetConn struct {
+	net.Conn
+	counts *countedConn
+}
+
+func (c *countingNetConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counts.received.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingNetConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counts.sent.Add(uint64(n))
+	return n, err
+}
+
+// openCounted opens a connection whose sockets are counted. The
+// connection is lazy; Connect pings it. The driver skips opts.TLS when
+// given a dialer, so TLS is layered over the counted socket here and the
+// counts are the encrypted bytes.
+func openCounted(opts *clickhouse.Options, compression string) (*countedConn, error) {
+	counted := &countedConn{compression: compression}
+	dialer := net.Dialer{Timeout: opts.DialTimeout}
+	tlsConfig := opts.TLS
+	opts.DialContext = func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		var netConn net.Conn = &countingNetConn{Conn: conn, counts: counted}
+		if tlsConfig != nil {
+			tlsConn := tls.Client(netConn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			netConn = tlsConn
+		}
+		return netConn, nil
+	}
+
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	counted.Conn = conn
+	return counted, nil
+}
+
+// wireCounts returns the compression and the bytes sent and received so
+// far by a connection from Connect. Other connections report zeros.
+func wireCounts(conn driver.Conn) (string, uint64, uint64) {
+	counted, ok := conn.(*countedConn)
+	if !ok {
+		return "", 0, 0
+	}
+	return counted.compression, counted.sent.Load(), counted.received.Load()
+}
+
+func newWireStats(compression string, raw, wire uint64) *models.WireStats {
+	stats := &models.WireStats{Compression: compression, RawBytes: raw, WireBytes: wire}
+	if raw > 0 && wire > 0 {
+		stats.Ratio = float64(raw) / float64(wire)
+	}
+	return stats
+}
+
+// addWireStats sums the stats of several connections of one job.
+func addWireStats(total, stats *models.WireStats) *models.WireStats {
+	if stats == nil {
+		return total
+	}
+	if total == nil {
+		return newWireStats(stats.Compression, stats.RawBytes, stats.WireBytes)
+	}
+	return newWireStats(total.Compression, total.RawBytes+stats.RawBytes, total.WireBytes+stats.WireBytes)
+}
+
+// httpCompressor returns the writer for an HTTP Content-Encoding, or nil
+// for none.
+func httpCompressor(encoding string) (func(io.Writer) io.WriteCloser, error) {
+	switch encoding {
+	case "none":
+		return nil, nil
+	case "gzip":
+		return func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, nil
+	case "deflate":
+		// HTTP deflate is the zlib format
+		return func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, nil
+	case "br":
+		return func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }, nil
+	}
+	return nil, fmt.Errorf("unknown HTTP compression %q, expected none, gzip, deflate or br", encoding)
+}
+
+// compressBody streams r through a compressor. The returned reader must be
+// read to the end or closed.
+func compressBody(newWriter func(io.Writer) io.WriteCloser, r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w := newWriter(pw)
+		_, err := io.Copy(w, r)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
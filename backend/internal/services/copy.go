@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// createTableNamePattern matches the table name of SHOW CREATE TABLE output,
+// optionally qualified, where either part may be quoted with backticks or
+// double quotes and then hold spaces, dots or parentheses.
+var createTableNamePattern = regexp.MustCompile(`^(?i)(CREATE\s+TABLE)\s+` + createTableIdent + `(?:\.` + createTableIdent + `)?`)
+
+const createTableIdent = "(?:`(?:[^`\\\\]|\\\\.|``)*`|\"(?:[^\"\\\\]|\\\\.)*\"|[^\\s(.`\"]+)"
+
+// CopyTable copies rows from the source connection into the target. In
+// stream mode rows pass through this process in batches of BatchSize; in
+// remote mode the target server runs INSERT ... SELECT FROM remote() and
+// the data never leaves the ClickHouse servers.
+func (s *ClickHouseService) CopyTable(source, target driver.Conn, req models.CopyRequest) (*models.CopyResult, error) {
+	if req.SourceTable == "" {
+		return nil, fmt.Errorf("source table is required")
+	}
+	if req.TargetTable == "" {
+		req.TargetTable = req.SourceTable
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = s.config.BatchSize
+	}
+
+	s.logger.Debug("copy started", "source_table", req.SourceTable, "source_host", req.Source.Host,
+		"target_table", req.TargetTable, "target_host", req.Target.Host, "remote", req.UseRemote)
+
+	result := &models.CopyResult{Mode: models.CopyModeStream}
+	if req.CreateTable {
+		created, err := s.createTableLike(source, target, req)
+		if err != nil {
+			return nil, err
+		}
+		result.TableCreated = created
+	}
+
+	if req.UseRemote {
+		result.Mode = models.CopyModeRemote
+		rows, err := s.copyRemote(source, target, req)
+		if err != nil {
+			return nil, err
+		}
+		result.RowsCopied = rows
+		return result, nil
+	}
+
+	rows, batches, err := s.copyStream(source, target, req)
+	if err != nil {
+		return nil, err
+	}
+	result.RowsCopied = rows
+	result.Batches = batches
+
+	return result, nil
+}
+
+// createTableLike creates the target table from the source's SHOW CREATE
+// TABLE with only the table name replaced. Engines that embed a path, such
+// as ReplicatedMergeTree, keep the source's path and may need the target
+// created by hand instead. It reports false if the table already existed.
+func (s *ClickHouseService) createTableLike(source, target driver.Conn, req models.CopyRequest) (bool, error) {
+	targetDB, targetTable := splitTableName(req.Target.Database, req.TargetTable)
+	exists, err := tableExists(target, targetDB, targetTable)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	var ddl string
+	query := "SHOW CREATE TABLE " + quoteTableName(qualifiedName(req.Source.Database, req.SourceTable))
+	if err := source.QueryRow(context.Background(), query).Scan(&ddl); err != nil {
+		return false, fmt.Errorf("failed to read source table definition: %v", err)
+	}
+
+	if !createTableNamePattern.MatchString(ddl) {
+		return false, fmt.Errorf("source %s is not a plain table and cannot be recreated", req.SourceTable)
+	}
+	ddl = createTableNamePattern.ReplaceAllLiteralString(ddl,
+		"CREATE TABLE IF NOT EXISTS "+quoteIdentifier(targetDB)+"."+quoteIdentifier(targetTable))
+
+	if err := target.Exec(context.Background(), ddl); err != nil {
+		return false, fmt.Errorf("failed to create target table: %v", err)
+	}
+	return true, nil
+}
+
+func (s *ClickHouseService) copyStream(source, target driver.Conn, req models.CopyRequest) (uint64, int, error) {
+	query := buildSelectQuery(qualifiedName(req.Source.Database, req.SourceTable), req.Columns)
+
+	ctx := s.Limits.readOnlyContext(context.Background())
+	rows, err := source.Query(ctx, query)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read source table: %w", s.Limits.wrapLimitError(err))
+	}
+	defer rows.Close()
+
+	columnTypes := rows.ColumnTypes()
+	names := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		names[i] = quoteIdentifier(ct.Name())
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s)",
+		quoteTableName(qualifiedName(req.Target.Database, req.TargetTable)), strings.Join(names, ", "))
+
+	var copied uint64
+	batches := 0
+	var batch driver.Batch
+	defer func() {
+		if batch != nil {
+			batch.Abort()
+		}
+	}()
+	for rows.Next() {
+		if batch == nil {
+			if batch, err = target.PrepareBatch(context.Background(), insert); err != nil {
+				return copied, batches, fmt.Errorf("failed to prepare batch: %v", err)
+			}
+		}
+
+		row, err := scanRow(rows, columnTypes)
+		if err != nil {
+			return copied, batches, err
+		}
+		if err := batch.Append(row...); err != nil {
+			return copied, batches, fmt.Errorf("failed to append row %d: %v", copied, err)
+		}
+
+		if batch.Rows() >= req.BatchSize {
+			if err := batch.Send(); err != nil {
+				return copied, batches, fmt.Errorf("failed to send batch %d: %v", batches+1, err)
+			}
+			copied += uint64(batch.Rows())
+			batches++
+			batch = nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return copied, batches, fmt.Errorf("error reading source rows: %w", s.Limits.wrapLimitError(err))
+	}
+
+	if batch != nil && batch.Rows() > 0 {
+		if err := batch.Send(); err != nil {
+			return copied, batches, fmt.Errorf("failed to send batch %d: %v", batches+1, err)
+		}
+		copied += uint64(batch.Rows())
+		batches++
+		batch = nil
+	}
+
+	return copied, batches, nil
+}
+
+// copyRemote runs the copy on the target server. The row count is taken
+// from the source just before the insert, so rows written concurrently to
+// the source may make it slightly off. The source credentials become part
+// of a query on the target, so the default server's own account is only
+// used when the target is the default server too.
+func (s *ClickHouseService) copyRemote(source, target driver.Conn, req models.CopyRequest) (uint64, error) {
+	sourceDB, sourceTable := splitTableName(req.Source.Database, req.SourceTable)
+	for _, config := range []*models.ClickHouseConfig{&req.Source, &req.Target} {
+		if config.Host == "" {
+			config.Host = s.config.Host
+		}
+		if config.Port == 0 {
+			config.Port = s.config.Port
+		}
+	}
+	if req.Source.User == "" && req.Source.Password == "" && !s.isDefaultServer(req.Target) {
+		return 0, fmt.Errorf("%w for the source of a remote copy to %s:%d; use stream mode to copy with the default account",
+			ErrCredentialsRequired, req.Target.Host, req.Target.Port)
+	}
+
+	var count uint64
+	countQuery := "SELECT count() FROM " + quoteIdentifier(sourceDB) + "." + quoteIdentifier(sourceTable)
+	if err := source.QueryRow(context.Background(), countQuery).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count source rows: %v", err)
+	}
+
+	selectList := "*"
+	columnList := ""
+	if len(req.Columns) > 0 {
+		quoted := make([]string, len(req.Columns))
+		for i, col := range req.Columns {
+			quoted[i] = quoteIdentifier(col)
+		}
+		selectList = strings.Join(quoted, ", ")
+		columnList = " (" + selectList + ")"
+	}
+
+	port := req.Source.Port
+	auth, err := s.auth(req.Source)
+	if err != nil {
+		return 0, err
+	}
+
+	remote := "remote"
+	if req.Source.Secure {
+		remote = "remoteSecure"
+	}
+	query := fmt.Sprintf("INSERT INTO %s%s SELECT %s FROM %s(?, ?, ?, ?, ?)",
+		quoteTableName(qualifiedName(req.Target.Database, req.TargetTable)), columnList, selectList, remote)
+	// remote() resolves the address from the target server, so the source
+	// host must be reachable from there, not just from this process
+	err = target.Exec(context.Background(), query,
+		fmt.Sprintf("%s:%d", req.Source.Host, port), sourceDB, sourceTable, auth.Username, auth.Password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy through remote(): %v", err)
+	}
+
+	return count, nil
+}
+
+func tableExists(conn driver.Conn, database, table string) (bool, error) {
+	var count uint64
+	if err := conn.QueryRow(context.Background(),
+		"SELECT count() FROM system.tables WHERE database = ? AND name = ?", database, table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check table: %v", err)
+	}
+	return count > 0, nil
+}
+
+// qualifiedName prefixes table with database unless it is already
+// qualified.
+func qualifiedName(database, table string) string {
+	if strings.Contains(table, ".") || database == "" {
+		return table
+	}
+	return database + "." + table
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+func TestCreateTableNamePattern(t *testing.T) {
+	const replacement = "CREATE TABLE IF NOT EXISTS `copy`.`t`"
+	tests := []struct {
+		ddl  string
+		want string
+	}{
+		{"CREATE TABLE db.events\n(\n    `id` UInt64\n)", replacement + "\n(\n    `id` UInt64\n)"},
+		{"CREATE TABLE db.events(`id` UInt64)", replacement + "(`id` UInt64)"},
+		{"CREATE TABLE `my db`.`my (events)`\n(", replacement + "\n("},
+		{"CREATE TABLE db.`a\\`b.c`\n(", replacement + "\n("},
+		{"create table \"db\".\"x y\" (", replacement + " ("},
+	}
+	for _, tt := range tests {
+		if !createTableNamePattern.MatchString(tt.ddl) {
+			t.Errorf("%q: no match", tt.ddl)
+			continue
+		}
+		if got := createTableNamePattern.ReplaceAllLiteralString(tt.ddl, replacement); got != tt.want {
+			t.Errorf("%q:\n got %q\nwant %q", tt.ddl, got, tt.want)
+		}
+	}
+
+	if createTableNamePattern.MatchString("CREATE VIEW db.v AS SELECT 1") {
+		t.Error("view DDL matched")
+	}
+}
+
+// failingRows yields one row whose scan fails.
+type failingRows struct {
+	driver.Rows
+	done bool
+}
+
+func (r *failingRows) Next() bool {
+	next := !r.done
+	r.done = true
+	return next
+}
+
+func (r *failingRows) Scan(dest ...any) error { return errors.New("scan failed") }
+
+func (r *failingRows) ColumnTypes() []driver.ColumnType {
+	return []driver.ColumnType{stringColumnType{}}
+}
+
+func (r *failingRows) Err() error   { return nil }
+func (r *failingRows) Close() error { return nil }
+
+type stringColumnType struct{ driver.ColumnType }
+
+func (stringColumnType) Name() string             { return "s" }
+func (stringColumnType) ScanType() reflect.Type   { return reflect.TypeOf("") }
+func (stringColumnType) DatabaseTypeName() string { return "String" }
+func (stringColumnType) Nullable() bool           { return false }
+
+type rowsConn struct {
+	driver.Conn
+	rows driver.Rows
+}
+
+func (c *rowsConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	return c.rows, nil
+}
+
+func TestCopyStreamAbortsBatchOnError(t *testing.T) {
+	s := newTestInsertService(10)
+	source := &rowsConn{rows: &failingRows{}}
+	target := &recordingConn{}
+
+	_, _, err := s.copyStream(source, target, models.CopyRequest{SourceTable: "t", TargetTable: "t", BatchSize: 10})
+	if err == nil {
+		t.Fatal("want the scan error")
+	}
+	if !target.batch.aborted {
+		t.Error("batch was not aborted")
+	}
+}
+
+// remoteConn answers the row count on the source and records the
+// remote() insert run on the target.
+type remoteConn struct {
+	driver.Conn
+	execArgs []interface{}
+}
+
+func (c *remoteConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	return countRow{}
+}
+
+func (c *remoteConn) Exec(ctx context.Context, query string, args ...any) error {
+	c.execArgs = args
+	return nil
+}
+
+type countRow struct{ driver.Row }
+
+func (countRow) Scan(dest ...any) error {
+	*dest[0].(*uint64) = 3
+	return nil
+}
+
+func TestCopyRemoteKeepsDefaultCredentialsOnDefaultServer(t *testing.T) {
+	s := &ClickHouseService{config: config.ClickHouseConfig{Host: "clickhouse", Port: 9000, User: "default", Password: "secret"}}
+	defaultServer := models.ClickHouseConfig{Host: "clickhouse", Port: 9000, Database: "db"}
+	otherServer := models.ClickHouseConfig{Host: "evil.example", Port: 9000, Database: "db"}
+
+	target := &remoteConn{}
+	req := models.CopyRequest{Source: defaultServer, Target: otherServer, SourceTable: "t", UseRemote: true}
+	if _, err := s.copyRemote(&remoteConn{}, target, req); !errors.Is(err, ErrCredentialsRequired) {
+		t.Fatalf("default credentials to another server: got %v, want ErrCredentialsRequired", err)
+	}
+	if target.execArgs != nil {
+		t.Error("remote() ran on the target")
+	}
+
+	req.Source.User, req.Source.Password = "reader", "pw"
+	if _, err := s.copyRemote(&remoteConn{}, target, req); err != nil {
+		t.Fatalf("explicit source credentials: %v", err)
+	}
+	if target.execArgs[3] != "reader" || target.execArgs[4] != "pw" {
+		t.Errorf("remote() got credentials %v, want the source's own", target.execArgs[3:])
+	}
+
+	target = &remoteConn{}
+	req = models.CopyRequest{Source: defaultServer, Target: defaultServer, SourceTable: "t", TargetTable: "t2", UseRemote: true}
+	if _, err := s.copyRemote(&remoteConn{}, target, req); err != nil {
+		t.Fatalf("copy within the default server: %v", err)
+	}
+	if target.execArgs[3] != "default" || target.execArgs[4] != "secret" {
+		t.Errorf("remote() got credentials %v, want the default account", target.execArgs[3:])
+	}
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// exportSlice is the part of a table one parallel export query reads.
+// predicate is ANDed with the request's filters and args are its bound
+// values; an empty predicate reads the whole table.
+type exportSlice struct {
+	models.ExportSlice
+	predicate string
+	args      []interface{}
+}
+
+// ExportSlices splits a table export as req.Parallel asks and reads the
+// slices concurrently on a pool of connections: conn serves the first
+// worker and req.Config opens the others. handle is called for each slice
+// as soon as it is read, possibly from several goroutines at once. The
+// first failure stops slices that have not started yet.
+//
+// Slices never overlap and together cover the table at planning time. Rows
+// with a NULL or NaN key fall in no key range and are read by an extra last
+// key_range slice; a hash split reads NULL keys in its first slice.
+func (s *ClickHouseService) ExportSlices(conn driver.Conn, req models.ExportRequest, handle func(slice models.ExportSlice, result *models.ExportResult) error) ([]models.ExportSlice, error) {
+	if err := validateParallelExport(req, s.config.MaxExportSlices); err != nil {
+		return nil, err
+	}
+
+	workers := s.config.ExportWorkers
+	if req.Parallel.Concurrency > 0 && req.Parallel.Concurrency < workers {
+		workers = req.Parallel.Concurrency
+	}
+
+	slices, err := s.planExportSlices(conn, req, workers)
+	if err != nil {
+		return nil, err
+	}
+	if workers > len(slices) {
+		workers = len(slices)
+	}
+	s.logger.Debug("parallel export started", "table", req.Table, "slices", len(slices), "split_by", req.Parallel.SplitBy, "workers", workers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	pending := make(chan *exportSlice, len(slices))
+	for _, slice := range slices {
+		pending <- slice
+	}
+	close(pending)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			workerConn := conn
+			if w > 0 {
+				c, err := s.Connect(req.Config)
+				if err != nil {
+					fail(fmt.Errorf("export worker %d failed to connect: %v", w, err))
+					return
+				}
+				defer c.Close()
+				workerConn = c
+			}
+
+			for slice := range pending {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := s.exportSlice(workerConn, req, slice, handle); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	done := make([]models.ExportSlice, len(slices))
+	for i, slice := range slices {
+		done[i] = slice.ExportSlice
+	}
+	return done, nil
+}
+
+func (s *ClickHouseService) exportSlice(conn driver.Conn, req models.ExportRequest, slice *exportSlice, handle func(models.ExportSlice, *models.ExportResult) error) error {
+	query, args, err := buildExportQuery(req, nil, slice)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	result, err := s.queryReadOnly(conn, query, args...)
+	if err != nil {
+		return fmt.Errorf("slice %s: %w", slice.Label, err)
+	}
+	slice.Rows = len(result.Rows)
+	slice.DurationMs = time.Since(started).Milliseconds()
+
+	if err := handle(slice.ExportSlice, result); err != nil {
+		return fmt.Errorf("slice %s: %w", slice.Label, err)
+	}
+	return nil
+}
+
+// exportParallel merges the slices of a parallel export into one result,
+// in slice order. MaxResultRows applies to the merged result as it would
+// to a single query.
+func (s *ClickHouseService) exportParallel(conn driver.Conn, req models.ExportRequest) (*models.ExportResult, error) {
+	var mu sync.Mutex
+	var total uint64
+	results := make(map[int]*models.ExportResult)
+	slices, err := s.ExportSlices(conn, req, func(slice models.ExportSlice, result *models.ExportResult) error {
+		mu.Lock()
+		defer mu.Unlock()
+		total += uint64(len(result.Rows))
+		if limit := s.Limits.MaxResultRows; limit > 0 && total > limit {
+			return &QueryLimitError{
+				Limit: fmt.Sprintf("%d result rows", limit),
+				Err:   fmt.Errorf("slices returned %d rows in total", total),
+			}
+		}
+		results[slice.Index] = result
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &models.ExportResult{Rows: [][]interface{}{}, Slices: slices}
+	for _, slice := range slices {
+		result := results[slice.Index]
+		if merged.Columns == nil {
+			merged.Columns = result.Columns
+		}
+		merged.Rows = append(merged.Rows, result.Rows...)
+		merged.Wire = addWireStats(merged.Wire, result.Wire)
+	}
+	return merged, nil
+}
+
+// validateParallelExport rejects what cannot be split: custom queries, and
+// ordering, limits and watermarks, which only hold across a single query.
+// It also rejects more than maxSlices slices.
+func validateParallelExport(req models.ExportRequest, maxSlices int) error {
+	if req.Table == "" || req.Query != "" {
+		return fmt.Errorf("parallel export needs a table and cannot run a custom query")
+	}
+	if len(req.OrderBy) > 0 || req.Limit > 0 || req.Offset > 0 || req.Incremental != nil {
+		return fmt.Errorf("ordering, limits and incremental mode cannot be combined with a parallel export")
+	}
+	switch req.Parallel.SplitBy {
+	case models.SplitPartition, models.SplitKeyRange, models.SplitHash:
+	default:
+		return fmt.Errorf("unknown split %q, expected partition, key_range or hash", req.Parallel.SplitBy)
+	}
+	if req.Parallel.Slices < 0 || req.Parallel.Concurrency < 0 {
+		return fmt.Errorf("slices and concurrency must not be negative")
+	}
+	if req.Parallel.Slices > maxSlices {
+		return fmt.Errorf("a parallel export may have at most %d slices", maxSlices)
+	}
+	return nil
+}
+
+// planExportSlices splits the table. When there is nothing to split, e.g.
+// an empty table or one that is not a MergeTree, a single slice reads it
+// all.
+func (s *ClickHouseService) planExportSlices(conn driver.Conn, req models.ExportRequest, workers int) ([]*exportSlice, error) {
+	count := req.Parallel.Slices
+	if count == 0 {
+		count = workers
+	}
+
+	var slices []*exportSlice
+	var err error
+	switch req.Parallel.SplitBy {
+	case models.SplitPartition:
+		slices, err = s.partitionSlices(conn, req)
+	case models.SplitKeyRange:
+		slices, err = s.keyRangeSlices(conn, req, count)
+	case models.SplitHash:
+		slices, err = s.hashSlices(conn, req, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(slices) == 0 {
+		slices = []*exportSlice{{ExportSlice: models.ExportSlice{Label: "all"}}}
+	}
+	if len(slices) > s.config.MaxExportSlices {
+		return nil, fmt.Errorf("table splits into %d slices, more than the %d allowed; use a key_range or hash split",
+			len(slices), s.config.MaxExportSlices)
+	}
+	for i, slice := range slices {
+		slice.Index = i
+	}
+	return slices, nil
+}
+
+// partitionSlices reads one slice per active partition through the
+// _partition_id virtual column.
+func (s *ClickHouseService) partitionSlices(conn driver.Conn, req models.ExportRequest) ([]*exportSlice, error) {
+	database, table := splitTableName(req.Config.Database, req.Table)
+	rows, err := conn.Query(context.Background(), `
+		SELECT partition_id, any(partition)
+		FROM system.parts
+		WHERE database = ? AND table = ? AND active
+		GROUP BY partition_id
+		ORDER BY partition_id
+	`, database, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %v", err)
+	}
+	defer rows.Close()
+
+	var slices []*exportSlice
+	for rows.Next() {
+		var id, partition string
+		if err := rows.Scan(&id, &partition); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %v", err)
+		}
+		slices = append(slices, &exportSlice{
+			ExportSlice: models.ExportSlice{Label: "partition " + partition},
+			predicate:   "_partition_id = ?",
+			args:        []interface{}{id},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating partitions: %v", err)
+	}
+	return slices, nil
+}
+
+// keyRangeSlices cuts the key's value range at approximate quantiles, so
+// slices hold similar numbers of rows. Only numeric and date keys have
+// quantiles. NULL and NaN keys fall in no range, so a Nullable or Float key
+// gets a last slice for them.
+func (s *ClickHouseService) keyRangeSlices(conn driver.Conn, req models.ExportRequest, count int) ([]*exportSlice, error) {
+	key, err := s.sliceKey(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	info := ParseType(key.Type)
+	name := info.Name
+	if !isNumericType(name) && !strings.HasPrefix(name, "Date") {
+		return nil, fmt.Errorf("key_range needs a numeric or date key, %s is %s; use a hash split", key.Name, key.Type)
+	}
+	if count < 2 {
+		return nil, nil
+	}
+
+	levels := make([]string, count-1)
+	for i := range levels {
+		levels[i] = fmt.Sprintf("%g", float64(i+1)/float64(count))
+	}
+	column := quoteIdentifier(key.Name)
+	query := fmt.Sprintf("SELECT arrayJoin(quantiles(%s)(%s)) FROM %s",
+		strings.Join(levels, ", "), column, quoteTableName(qualifiedName(req.Config.Database, req.Table)))
+	rows, err := conn.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key ranges: %v", err)
+	}
+	defer rows.Close()
+
+	// Quantiles of an empty table are NULL or NaN; equal neighbours would
+	// only make empty slices
+	var bounds []interface{}
+	columnTypes := rows.ColumnTypes()
+	for rows.Next() {
+		row, err := scanRow(rows, columnTypes)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := deref(row[0])
+		if !ok || (value.Kind() == reflect.Float64 && math.IsNaN(value.Float())) {
+			continue
+		}
+		if len(bounds) > 0 && reflect.DeepEqual(bounds[len(bounds)-1], value.Interface()) {
+			continue
+		}
+		bounds = append(bounds, value.Interface())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating key ranges: %v", err)
+	}
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+
+	formatter, _ := NewValueFormatter(models.ExportFormat{})
+	boundType := columnTypes[0].DatabaseTypeName()
+	label := func(v interface{}) string { return formatter.Format(v, boundType) }
+
+	slices := []*exportSlice{{
+		ExportSlice: models.ExportSlice{Label: fmt.Sprintf("%s < %s", key.Name, label(bounds[0]))},
+		predicate:   fmt.Sprintf("%s < ?", column),
+		args:        []interface{}{bounds[0]},
+	}}
+	for i := 1; i < len(bounds); i++ {
+		slices = append(slices, &exportSlice{
+			ExportSlice: models.ExportSlice{Label: fmt.Sprintf("%s <= %s < %s", label(bounds[i-1]), key.Name, label(bounds[i]))},
+			predicate:   fmt.Sprintf("%s >= ? AND %s < ?", column, column),
+			args:        []interface{}{bounds[i-1], bounds[i]},
+		})
+	}
+	last := bounds[len(bounds)-1]
+	slices = append(slices, &exportSlice{
+		ExportSlice: models.ExportSlice{Label: fmt.Sprintf("%s >= %s", key.Name, label(last))},
+		predicate:   fmt.Sprintf("%s >= ?", column),
+		args:        []interface{}{last},
+	})
+	return append(slices, unorderedKeySlice(key.Name, info)...), nil
+}
+
+// unorderedKeySlice returns the slice of the rows whose key compares false
+// with every bound: NULLs of a Nullable key and NaNs of a Float key.
+func unorderedKeySlice(name string, info models.TypeInfo) []*exportSlice {
+	column := quoteIdentifier(name)
+	var predicates, labels []string
+	if info.Nullable {
+		predicates = append(predicates, column+" IS NULL")
+		labels = append(labels, "NULL")
+	}
+	if strings.HasPrefix(info.Name, "Float") {
+		predicates = append(predicates, "isNaN("+column+")")
+		labels = append(labels, "NaN")
+	}
+	if len(predicates) == 0 {
+		return nil
+	}
+	return []*exportSlice{{
+		ExportSlice: models.ExportSlice{Label: fmt.Sprintf("%s is %s", name, strings.Join(labels, " or "))},
+		predicate:   "(" + strings.Join(predicates, " OR ") + ")",
+	}}
+}
+
+// hashSlices splits by cityHash64(key) % count, which spreads any key type
+// evenly.
+func (s *ClickHouseService) hashSlices(conn driver.Conn, req models.ExportRequest, count int) ([]*exportSlice, error) {
+	key, err := s.sliceKey(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	if count < 2 {
+		return nil, nil
+	}
+
+	column := quoteIdentifier(key.Name)
+	slices := make([]*exportSlice, count)
+	for i := range slices {
+		predicate := fmt.Sprintf("cityHash64(%s) %% ? = ?", column)
+		if i == 0 {
+			predicate = fmt.Sprintf("(%s OR %s IS NULL)", predicate, column)
+		}
+		slices[i] = &exportSlice{
+			ExportSlice: models.ExportSlice{Label: fmt.Sprintf("hash %d/%d", i, count)},
+			predicate:   predicate,
+			args:        []interface{}{uint64(count), uint64(i)},
+		}
+	}
+	return slices, nil
+}
+
+// sliceKey returns the requested key column, or else the first column in
+// table order that is part of the primary key.
+func (s *ClickHouseService) sliceKey(conn driver.Conn, req models.ExportRequest) (*models.Column, error) {
+	columns, err := s.GetColumns(conn, req.Config.Database, req.Table)
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range columns {
+		if req.Parallel.Key == "" && col.InPrimaryKey || col.Name == req.Parallel.Key {
+			return &columns[i], nil
+		}
+	}
+	if req.Parallel.Key == "" {
+		return nil, fmt.Errorf("table %s has no primary key; name a key column to split by", req.Table)
+	}
+	return nil, fmt.Errorf("key column %s does not exist in table %s", req.Parallel.Key, req.Table)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+func TestUnorderedKeySlice(t *testing.T) {
+	tests := []struct {
+		keyType   string
+		label     string
+		predicate string
+	}{
+		{"UInt64", "", ""},
+		{"Nullable(Date)", "k is NULL", "(`k` IS NULL)"},
+		{"Float64", "k is NaN", "(isNaN(`k`))"},
+		{"Nullable(Float32)", "k is NULL or NaN", "(`k` IS NULL OR isNaN(`k`))"},
+	}
+	for _, tt := range tests {
+		slices := unorderedKeySlice("k", ParseType(tt.keyType))
+		if tt.predicate == "" {
+			if len(slices) != 0 {
+				t.Errorf("%s: got %d slices, want none", tt.keyType, len(slices))
+			}
+			continue
+		}
+		if len(slices) != 1 {
+			t.Fatalf("%s: got %d slices, want 1", tt.keyType, len(slices))
+		}
+		if slices[0].Label != tt.label || slices[0].predicate != tt.predicate {
+			t.Errorf("%s: got %q %q, want %q %q", tt.keyType, slices[0].Label, slices[0].predicate, tt.label, tt.predicate)
+		}
+	}
+}
+
+// partitionedConn serves a table with the given number of partitions and
+// rowsPerSlice rows in each slice query.
+type partitionedConn struct {
+	driver.Conn
+	partitions   int
+	rowsPerSlice int
+}
+
+func (c *partitionedConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	if strings.Contains(query, "system.parts") {
+		return &valueRows{columns: 2, remaining: c.partitions}, nil
+	}
+	return &valueRows{columns: 1, remaining: c.rowsPerSlice}, nil
+}
+
+// valueRows yields remaining rows of string columns.
+type valueRows struct {
+	driver.Rows
+	columns   int
+	remaining int
+}
+
+func (r *valueRows) Next() bool {
+	r.remaining--
+	return r.remaining >= 0
+}
+
+func (r *valueRows) Scan(dest ...any) error {
+	for _, d := range dest {
+		*d.(*string) = fmt.Sprint(r.remaining)
+	}
+	return nil
+}
+
+func (r *valueRows) ColumnTypes() []driver.ColumnType {
+	types := make([]driver.ColumnType, r.columns)
+	for i := range types {
+		types[i] = stringColumnType{}
+	}
+	return types
+}
+
+func (r *valueRows) Err() error   { return nil }
+func (r *valueRows) Close() error { return nil }
+
+func newTestExportService(maxRows uint64) *ClickHouseService {
+	return &ClickHouseService{
+		Limits: QueryLimits{MaxResultRows: maxRows},
+		config: config.ClickHouseConfig{ExportWorkers: 1, MaxExportSlices: 4},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestParallelExportSliceLimit(t *testing.T) {
+	s := newTestExportService(0)
+	req := models.ExportRequest{Table: "t", Parallel: &models.ParallelExport{SplitBy: models.SplitHash, Slices: 5}}
+	if _, err := s.exportParallel(&partitionedConn{}, req); err == nil || !strings.Contains(err.Error(), "at most 4 slices") {
+		t.Errorf("5 requested slices: got %v", err)
+	}
+
+	req.Parallel = &models.ParallelExport{SplitBy: models.SplitPartition}
+	if _, err := s.exportParallel(&partitionedConn{partitions: 5}, req); err == nil || !strings.Contains(err.Error(), "5 slices") {
+		t.Errorf("5 partitions: got %v", err)
+	}
+	result, err := s.exportParallel(&partitionedConn{partitions: 4, rowsPerSlice: 2}, req)
+	if err != nil {
+		t.Fatalf("4 partitions: %v", err)
+	}
+	if len(result.Slices) != 4 || len(result.Rows) != 8 {
+		t.Errorf("got %d slices and %d rows, want 4 and 8", len(result.Slices), len(result.Rows))
+	}
+}
+
+func TestParallelExportLimitsMergedRows(t *testing.T) {
+	req := models.ExportRequest{Table: "t", Parallel: &models.ParallelExport{SplitBy: models.SplitPartition}}
+
+	// Every slice stays under the limit, their total does not
+	s := newTestExportService(7)
+	_, err := s.exportParallel(&partitionedConn{partitions: 4, rowsPerSlice: 2}, req)
+	var limitErr *QueryLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "7 result rows" {
+		t.Errorf("8 rows over a limit of 7: got %v, want a QueryLimitError", err)
+	}
+
+	s = newTestExportService(8)
+	if _, err := s.exportParallel(&partitionedConn{partitions: 4, rowsPerSlice: 2}, req); err != nil {
+		t.Errorf("8 rows at a limit of 8: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// WriteExportS3 writes an export result to the destination bucket, one
+// object per distinct value of partitionBy (or a single object), followed
+// by a manifest.json listing every object with its row count and SHA-256.
+// It returns the manifest and its key.
+func (s *SourceService) WriteExportS3(ctx context.Context, dest models.ExportDestination, partitionBy string, result *models.ExportResult, delimiter rune, formatter *ValueFormatter) (*models.ExportManifest, string, error) {
+	client, err := s.S3Client(dest.Endpoint, dest.Bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	if formatter == nil {
+		formatter, _ = NewValueFormatter(models.ExportFormat{})
+	}
+
+	names := make([]string, len(result.Columns))
+	types := make([]string, len(result.Columns))
+	partitionIndex := -1
+	for i, col := range result.Columns {
+		names[i], types[i] = col.Name, col.Type
+		if col.Name == partitionBy {
+			partitionIndex = i
+		}
+	}
+	if partitionBy != "" && partitionIndex < 0 {
+		return nil, "", fmt.Errorf("partition column %s is not in the export", partitionBy)
+	}
+
+	// Group rows by partition value, keeping the order values first appear in
+	var partitions []string
+	groups := make(map[string][][]interface{})
+	for _, row := range result.Rows {
+		value := ""
+		if partitionIndex >= 0 {
+			value = formatter.Format(row[partitionIndex], types[partitionIndex])
+		}
+		if _, ok := groups[value]; !ok {
+			partitions = append(partitions, value)
+		}
+		groups[value] = append(groups[value], row)
+	}
+	if len(partitions) == 0 {
+		// An empty export still writes a header-only object
+		partitions = []string{""}
+	}
+
+	ext, contentType := ".csv", "text/csv; charset=utf-8"
+	if delimiter == '\t' {
+		ext, contentType = ".tsv", "text/tab-separated-values; charset=utf-8"
+	}
+	prefix := strings.Trim(dest.Prefix, "/")
+
+	manifest := &models.ExportManifest{
+		Bucket:      dest.Bucket,
+		Prefix:      prefix,
+		Columns:     result.Columns,
+		PartitionBy: partitionBy,
+		Objects:     []models.ManifestObject{},
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	for _, value := range partitions {
+		key := path.Join(prefix, "data"+ext)
+		if partitionIndex >= 0 {
+			// Hive-style keys let engines that read the bucket prune by value
+			key = path.Join(prefix, partitionBy+"="+partitionSegment(value), "data"+ext)
+		}
+
+		rows := groups[value]
+		object, err := s.putCSV(ctx, client, dest.Bucket, key, contentType, names, types, rows, delimiter, formatter)
+		if err != nil {
+			return nil, "", err
+		}
+		if partitionIndex >= 0 {
+			object.Partition = value
+		}
+		manifest.Objects = append(manifest.Objects, *object)
+		manifest.Rows += object.Rows
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	manifestKey := path.Join(prefix, "manifest.json")
+	if err := client.PutObject(ctx, dest.Bucket, manifestKey, "application/json", strings.NewReader(string(data))); err != nil {
+		return nil, "", err
+	}
+
+	return manifest, manifestKey, nil
+}
+
+// putCSV streams rows as CSV into one object, hashing and counting the
+// bytes on the way.
+func (s *SourceService) putCSV(ctx context.Context, client *s3Client, bucket, key, contentType string, header, types []string, rows [][]interface{}, delimiter rune, formatter *ValueFormatter) (*models.ManifestObject, error) {
+	pr, pw := io.Pipe()
+	hash := sha256.New()
+	counter := &countingWriter{}
+
+	go func() {
+		err := writeCSV(io.MultiWriter(pw, hash, counter), header, rows, types, delimiter, formatter)
+		pw.CloseWithError(err)
+	}()
+
+	err := client.PutObject(ctx, bucket, key, contentType, pr)
+	// Unblock the writer if the upload stopped reading early
+	pr.CloseWithError(fmt.Errorf("upload finished"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ManifestObject{
+		Key:    key,
+		Rows:   len(rows),
+		Size:   counter.n,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func partitionSegment(value string) string {
+	if value == "" {
+		return "__empty__"
+	}
+	return url.PathEscape(value)
+}
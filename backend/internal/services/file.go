@@ -1,44 +1,75 @@
 package services
 
 import (
+	"crypto/rand"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportExpired  = errors.New("export has expired")
+
+	fileIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
 )
 
 type FileService struct {
-	UploadDir string
+	UploadDir        string
+	ExportDir        string
+	ExportTTL        time.Duration
+	UploadTTL        time.Duration
+	MaxDiskUsage     int64
+	MaxResumableSize int64
+	MaxChunkSize     int64
+
+	// sessionLocks holds a *sync.Mutex per resumable upload so chunks of
+	// one upload are applied in order without blocking other uploads.
+	sessionLocks sync.Map
+
+	logger *slog.Logger
 }
 
-func NewFileService(uploadDir string) *FileService {
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		fmt.Printf("Warning: failed to create upload directory: %v\n", err)
-	}
-	return &FileService{UploadDir: uploadDir}
+// ExportFile describes an export written to ExportDir.
+type ExportFile struct {
+	ID        string
+	Path      string
+	Name      string
+	Size      int64
+	Owner     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
 
-func (s *FileService) SaveUploadedFile(file *multipart.FileHeader) (string, error) {
-	src, err := file.Open()
-	if err != nil {
-		return "", fmt.Errorf("failed to open uploaded file: %v", err)
+func NewFileService(cfg config.StorageConfig, logger *slog.Logger) *FileService {
+	if err := os.MkdirAll(cfg.UploadDir, 0755); err != nil {
+		logger.Warn("failed to create upload directory", "path", cfg.UploadDir, "error", err)
 	}
-	defer src.Close()
-
-	dstPath := filepath.Join(s.UploadDir, file.Filename)
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create destination file: %v", err)
+	if err := os.MkdirAll(cfg.ExportDir, 0755); err != nil {
+		logger.Warn("failed to create export directory", "path", cfg.ExportDir, "error", err)
 	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, src); err != nil {
-		return "", fmt.Errorf("failed to copy file: %v", err)
+	return &FileService{
+		UploadDir:        cfg.UploadDir,
+		ExportDir:        cfg.ExportDir,
+		ExportTTL:        cfg.ExportTTL,
+		UploadTTL:        cfg.UploadTTL,
+		MaxDiskUsage:     cfg.MaxDiskUsage,
+		MaxResumableSize: cfg.MaxResumableSize,
+		MaxChunkSize:     cfg.MaxChunkSize,
+		logger:           logger,
 	}
-
-	return dstPath, nil
 }
 
 func (s *FileService) ReadCSV(filePath string, delimiter rune) ([][]string, error) {
@@ -59,30 +90,197 @@ func (s *FileService) ReadCSV(filePath string, delimiter rune) ([][]string, erro
 	return records, nil
 }
 
-func (s *FileService) WriteCSV(filePath string, data [][]interface{}, delimiter rune) error {
+// WriteCSV writes data to filePath, rendering each value according to the
+// ClickHouse type at the same index in types. A nil formatter uses the
+// default layouts.
+func (s *FileService) WriteCSV(filePath string, data [][]interface{}, types []string, delimiter rune, formatter *ValueFormatter) error {
 	file, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
+	return writeCSV(file, nil, data, types, delimiter, formatter)
+}
+
+func writeCSV(w io.Writer, header []string, data [][]interface{}, types []string, delimiter rune, formatter *ValueFormatter) error {
+	if formatter == nil {
+		formatter, _ = NewValueFormatter(models.ExportFormat{})
+	}
+
+	writer := csv.NewWriter(w)
 	writer.Comma = delimiter
-	defer writer.Flush()
+
+	if header != nil {
+		if err := writer.Write(header); err != nil {
+			return fmt.Errorf("failed to write header: %v", err)
+		}
+	}
 
 	for _, row := range data {
 		strRow := make([]string, len(row))
 		for i, val := range row {
-			strRow[i] = fmt.Sprintf("%v", val)
+			strRow[i] = formatter.Format(val, typeAt(types, i))
 		}
 		if err := writer.Write(strRow); err != nil {
 			return fmt.Errorf("failed to write row: %v", err)
 		}
 	}
 
+	writer.Flush()
+	return writer.Error()
+}
+
+// Exports are stored in ExportDir as <id>.csv or <id>.tsv with a <id>.json
+// record of their owner beside it.
+var exportExtensions = []string{".csv", ".tsv"}
+
+type exportMeta struct {
+	Owner string `json:"owner,omitempty"`
+}
+
+// WriteExport writes the header and rows into a new file in ExportDir,
+// owned by owner, and returns the export metadata. Tab-delimited exports
+// get a .tsv extension.
+func (s *FileService) WriteExport(columns []string, types []string, data [][]interface{}, delimiter rune, formatter *ValueFormatter, owner string) (*ExportFile, error) {
+	id, err := newFileID()
+	if err != nil {
+		return nil, err
+	}
+
+	ext := ".csv"
+	if delimiter == '\t' {
+		ext = ".tsv"
+	}
+	path := filepath.Join(s.ExportDir, id+ext)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export file: %v", err)
+	}
+
+	err = writeCSV(file, columns, data, types, delimiter, formatter)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.writeExportMeta(id, exportMeta{Owner: owner})
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return s.statExport(id, path)
+}
+
+// OpenExport resolves an export ID to an open file. An empty owner skips
+// the ownership check; exports of other owners, and exports without an
+// owner record, are reported as not found like uploads. Expired exports
+// are removed and reported as ErrExportExpired.
+func (s *FileService) OpenExport(id, owner string) (*os.File, *ExportFile, error) {
+	if !fileIDPattern.MatchString(id) {
+		return nil, nil, ErrExportNotFound
+	}
+
+	path, ok := s.exportDataPath(id)
+	if !ok {
+		return nil, nil, ErrExportNotFound
+	}
+
+	export, err := s.statExport(id, path)
+	if err != nil {
+		return nil, nil, ErrExportNotFound
+	}
+	if owner != "" && export.Owner != owner {
+		return nil, nil, ErrExportNotFound
+	}
+	if time.Now().After(export.ExpiresAt) {
+		os.Remove(export.Path)
+		os.Remove(s.exportMetaPath(id))
+		return nil, nil, ErrExportExpired
+	}
+
+	file, err := os.Open(export.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open export: %v", err)
+	}
+
+	return file, export, nil
+}
+
+func (s *FileService) statExport(id, path string) (*ExportFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat export: %v", err)
+	}
+
+	export := &ExportFile{
+		ID:        id,
+		Path:      path,
+		Name:      "export_" + id + filepath.Ext(path),
+		Size:      info.Size(),
+		CreatedAt: info.ModTime(),
+		ExpiresAt: info.ModTime().Add(s.ExportTTL),
+	}
+	if meta, err := s.readExportMeta(id); err == nil {
+		export.Owner = meta.Owner
+	}
+	return export, nil
+}
+
+// exportDataPath returns the path of an export's data file, if it exists.
+func (s *FileService) exportDataPath(id string) (string, bool) {
+	for _, ext := range exportExtensions {
+		path := filepath.Join(s.ExportDir, id+ext)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (s *FileService) exportMetaPath(id string) string {
+	return filepath.Join(s.ExportDir, id+".json")
+}
+
+func (s *FileService) writeExportMeta(id string, meta exportMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode export metadata: %v", err)
+	}
+
+	path := s.exportMetaPath(id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write export metadata: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write export metadata: %v", err)
+	}
 	return nil
 }
 
+func (s *FileService) readExportMeta(id string) (*exportMeta, error) {
+	data, err := os.ReadFile(s.exportMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var meta exportMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func newFileID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate file id: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 func (s *FileService) GetFileColumns(filePath string, delimiter rune) ([]string, error) {
 	records, err := s.ReadCSV(filePath, delimiter)
 	if err != nil {
@@ -108,7 +306,3 @@ func (s *FileService) PreviewFile(filePath string, delimiter rune, limit int) ([
 
 	return records, nil
 }
-
-func (s *FileService) Cleanup(filePath string) error {
-	return os.Remove(filePath)
-} 
\ No newline at end of file
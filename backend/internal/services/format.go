@@ -0,0 +1,210 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	DefaultDateLayout     = "2006-01-02"
+	DefaultDateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ValueFormatter renders scanned ClickHouse values as text for flat file
+// output, using the column type to decide how each value is written.
+type ValueFormatter struct {
+	dateLayout       string
+	dateTimeLayout   string
+	location         *time.Location
+	nullValue        string
+	trimDecimalZeros bool
+}
+
+func NewValueFormatter(opts models.ExportFormat) (*ValueFormatter, error) {
+	f := &ValueFormatter{
+		dateLayout:       DefaultDateLayout,
+		dateTimeLayout:   DefaultDateTimeLayout,
+		nullValue:        opts.NullValue,
+		trimDecimalZeros: opts.TrimDecimalZeros,
+	}
+	if opts.DateLayout != "" {
+		f.dateLayout = opts.DateLayout
+	}
+	if opts.DateTimeLayout != "" {
+		f.dateTimeLayout = opts.DateTimeLayout
+	}
+	if opts.Timezone != "" {
+		loc, err := time.LoadLocation(opts.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", opts.Timezone, err)
+		}
+		f.location = loc
+	}
+	return f, nil
+}
+
+// Format renders a top-level cell value. chType may be empty when the column
+// type is unknown, in which case the Go type of the value decides.
+func (f *ValueFormatter) Format(value interface{}, chType string) string {
+	v, ok := deref(value)
+	if !ok {
+		return f.nullValue
+	}
+	return f.format(v, baseType(chType), false)
+}
+
+// format renders a non-nil value. Nested values inside Array, Map and Tuple
+// are quoted the way ClickHouse prints them in its text formats.
+func (f *ValueFormatter) format(v reflect.Value, chType string, nested bool) string {
+	switch val := v.Interface().(type) {
+	case time.Time:
+		return f.quote(f.formatTime(val, chType), nested)
+	case decimal.Decimal:
+		return f.formatDecimal(val, chType)
+	case string:
+		return f.quote(val, nested)
+	case []byte:
+		return f.quote(string(val), nested)
+	case bool:
+		return strconv.FormatBool(val)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case fmt.Stringer:
+		return f.quote(val.String(), nested)
+	}
+
+	// Types like big.Int only implement Stringer on the pointer receiver
+	if v.Kind() == reflect.Struct && v.CanAddr() {
+		if s, ok := v.Addr().Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if strings.HasPrefix(chType, "Tuple(") {
+			return f.formatTuple(v, typeArgs(chType))
+		}
+		return f.formatArray(v, elementType(chType))
+	case reflect.Map:
+		if strings.HasPrefix(chType, "Tuple(") {
+			return f.formatNamedTuple(v, typeArgs(chType))
+		}
+		return f.formatMap(v, typeArgs(chType))
+	}
+
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+func (f *ValueFormatter) formatTime(t time.Time, chType string) string {
+	if f.location != nil {
+		t = t.In(f.location)
+	}
+	if strings.HasPrefix(chType, "Date") && !strings.HasPrefix(chType, "DateTime") {
+		return t.Format(f.dateLayout)
+	}
+	if strings.HasPrefix(chType, "DateTime64(") && f.dateTimeLayout == DefaultDateTimeLayout {
+		if args := typeArgs(chType); len(args) > 0 {
+			if precision, err := strconv.Atoi(args[0]); err == nil && precision > 0 {
+				return t.Format(DefaultDateTimeLayout + "." + strings.Repeat("0", precision))
+			}
+		}
+	}
+	return t.Format(f.dateTimeLayout)
+}
+
+func (f *ValueFormatter) formatDecimal(d decimal.Decimal, chType string) string {
+	if f.trimDecimalZeros {
+		return d.String()
+	}
+	if args := typeArgs(chType); len(args) > 0 {
+		// Decimal(P, S) and the sized DecimalN(S) both carry the scale last
+		if scale, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			return d.StringFixed(int32(scale))
+		}
+	}
+	return d.String()
+}
+
+func (f *ValueFormatter) formatArray(v reflect.Value, elemType string) string {
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = f.formatNested(v.Index(i), elemType)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func (f *ValueFormatter) formatTuple(v reflect.Value, elemTypes []string) string {
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = f.formatNested(v.Index(i), typeAt(elemTypes, i))
+	}
+	return "(" + strings.Join(parts, ",") + ")"
+}
+
+// formatNamedTuple renders Tuple(a T1, b T2) values, which the driver
+// returns as map[string]interface{}, in declaration order.
+func (f *ValueFormatter) formatNamedTuple(v reflect.Value, elemTypes []string) string {
+	parts := make([]string, 0, len(elemTypes))
+	for _, elem := range elemTypes {
+		name, typ := splitTypeField(elem)
+		if name == "" {
+			continue
+		}
+		parts = append(parts, f.formatNested(v.MapIndex(reflect.ValueOf(name)), typ))
+	}
+	return "(" + strings.Join(parts, ",") + ")"
+}
+
+func (f *ValueFormatter) formatMap(v reflect.Value, kvTypes []string) string {
+	keyType, valueType := typeAt(kvTypes, 0), typeAt(kvTypes, 1)
+
+	entries := make([]string, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		entries = append(entries, f.formatNested(iter.Key(), keyType)+":"+f.formatNested(iter.Value(), valueType))
+	}
+	// Go maps have no order; sort so repeated exports are byte-identical
+	sort.Strings(entries)
+	return "{" + strings.Join(entries, ",") + "}"
+}
+
+func (f *ValueFormatter) formatNested(v reflect.Value, chType string) string {
+	if !v.IsValid() {
+		return "NULL"
+	}
+	inner, ok := deref(v.Interface())
+	if !ok {
+		return "NULL"
+	}
+	return f.format(inner, baseType(chType), true)
+}
+
+func (f *ValueFormatter) quote(s string, nested bool) string {
+	if !nested {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// deref unwraps pointers and interfaces, reporting false for NULL values.
+func deref(value interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, v.IsValid()
+}
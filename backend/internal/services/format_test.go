@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValueFormatter(t *testing.T) {
+	moment := time.Date(2024, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	one, name := int32(1), "x"
+	var nullInt *int32
+	var nullString *string
+
+	tests := []struct {
+		name   string
+		format models.ExportFormat
+		value  interface{}
+		chType string
+		want   string
+	}{
+		{"string", models.ExportFormat{}, "it's", "String", "it's"},
+		{"nullable string", models.ExportFormat{}, &name, "Nullable(String)", "x"},
+		{"NULL", models.ExportFormat{}, nullInt, "Nullable(Int32)", ""},
+		{"NULL value", models.ExportFormat{NullValue: `\N`}, nullString, "Nullable(String)", `\N`},
+		{"untyped nil", models.ExportFormat{NullValue: "null"}, nil, "", "null"},
+
+		{"decimal keeps scale", models.ExportFormat{}, decimal.RequireFromString("12.5"), "Decimal(18, 4)", "12.5000"},
+		{"sized decimal", models.ExportFormat{}, decimal.RequireFromString("-3"), "Decimal64(2)", "-3.00"},
+		{"decimal trimmed", models.ExportFormat{TrimDecimalZeros: true}, decimal.RequireFromString("12.5000"), "Decimal(18, 4)", "12.5"},
+		{"float", models.ExportFormat{}, 0.1, "Float64", "0.1"},
+		{"bool", models.ExportFormat{}, true, "Bool", "true"},
+
+		{"date", models.ExportFormat{}, moment, "Date", "2024-01-02"},
+		{"date layout", models.ExportFormat{DateLayout: "02/01/2006"}, moment, "Date32", "02/01/2024"},
+		{"datetime", models.ExportFormat{}, moment, "DateTime", "2024-01-02 03:04:05"},
+		{"datetime64 precision", models.ExportFormat{}, moment, "DateTime64(3, 'UTC')", "2024-01-02 03:04:05.678"},
+		{"datetime layout", models.ExportFormat{DateTimeLayout: time.RFC3339}, moment, "DateTime", "2024-01-02T03:04:05Z"},
+		{"timezone", models.ExportFormat{Timezone: "Asia/Tokyo"}, moment, "Nullable(DateTime)", "2024-01-02 12:04:05"},
+
+		{"array with NULL", models.ExportFormat{}, []*int32{&one, nil}, "Array(Nullable(Int32))", "[1,NULL]"},
+		{"array of strings", models.ExportFormat{}, []string{"a", "b'c"}, "Array(String)", `['a','b\'c']`},
+		{"tuple", models.ExportFormat{}, []interface{}{int8(1), "a", moment}, "Tuple(Int8, String, Date)", "(1,'a','2024-01-02')"},
+		{"named tuple in declaration order", models.ExportFormat{},
+			map[string]interface{}{"b": "x", "a": int8(1)}, "Tuple(b String, a Int8)", "('x',1)"},
+		{"quoted tuple names", models.ExportFormat{},
+			map[string]interface{}{"my field": "x", "n, (m)": decimal.RequireFromString("1.5"), "d": nil},
+			"Tuple(`my field` String, `n, (m)` Decimal(9, 2), d Nullable(Date))", "('x',1.50,NULL)"},
+		{"map sorted", models.ExportFormat{}, map[string]uint64{"b": 2, "a": 1}, "Map(String, UInt64)", "{'a':1,'b':2}"},
+	}
+	for _, tt := range tests {
+		f, err := NewValueFormatter(tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := f.Format(tt.value, tt.chType); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValueFormatterRejectsUnknownTimezone(t *testing.T) {
+	if _, err := NewValueFormatter(models.ExportFormat{Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("want an error")
+	}
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+// rawSampleSize is how much of a raw import is parsed locally before the
+// stream is handed to ClickHouse.
+const rawSampleSize = 64 * 1024
+
+// rawSampleRows is how many data rows of a CSV/TSV sample are checked.
+const rawSampleRows = 100
+
+// formatNamePattern matches ClickHouse format names such as CSVWithNames,
+// which are spliced into the INSERT after FORMAT.
+var formatNamePattern = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+// StartRawInsert validates a sample of body and then streams all of it to
+// the ClickHouse HTTP interface as INSERT ... FORMAT in the background. The
+// server parses the data; this side only counts bytes for progress and
+// reports the server's summary or error. The job owns body and closes it.
+func (s *ClickHouseService) StartRawInsert(config models.ClickHouseConfig, req models.RawImportRequest, body io.ReadCloser, source, owner string) (*models.IngestJob, error) {
+	if req.Table == "" {
+		body.Close()
+		return nil, fmt.Errorf("table is required")
+	}
+	if req.Format == "" {
+		req.Format = "CSVWithNames"
+	}
+	if !formatNamePattern.MatchString(req.Format) {
+		body.Close()
+		return nil, fmt.Errorf("invalid format %q", req.Format)
+	}
+
+	compressor, err := httpCompressor(config.HTTPCompression)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	auth, err := s.auth(config)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(body, rawSampleSize)
+	if err := validateRawSample(reader, req); err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	id, err := newFileID()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	query := "INSERT INTO " + quoteTableName(req.Table)
+	if len(req.Columns) > 0 {
+		names := make([]string, len(req.Columns))
+		for i, col := range req.Columns {
+			names[i] = quoteIdentifier(col)
+		}
+		query += " (" + strings.Join(names, ", ") + ")"
+	}
+	query += " FORMAT " + req.Format
+
+	params := url.Values{
+		"query":    {query},
+		"database": {config.Database},
+		"query_id": {id},
+		// Hold the response until the insert finishes so a failure late in
+		// the stream still turns into an error status
+		"wait_end_of_query": {"1"},
+	}
+	if req.Delimiter != "" {
+		params.Set("format_csv_delimiter", req.Delimiter[:1])
+	}
+
+	scheme := "http"
+	if config.Secure {
+		scheme = "https"
+	}
+	endpoint := url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(dialHost(config.Host), strconv.Itoa(config.HTTPPort)),
+		Path:     "/",
+		RawQuery: params.Encode(),
+	}
+
+	job := &ingestJob{job: models.IngestJob{
+		ID:          id,
+		QueryID:     id,
+		Status:      models.JobRunning,
+		Table:       req.Table,
+		Source:      source,
+		Compression: config.HTTPCompression,
+		Owner:       owner,
+		StartedAt:   time.Now().UTC(),
+	}}
+	s.pruneIngestJobs()
+	s.ingestJobs.Store(id, job)
+
+	logger := s.logger.With("job_id", id, "table", req.Table)
+	go func() {
+		defer body.Close()
+		started := time.Now()
+		logger.Info("raw insert started", "format", req.Format, "compression", config.HTTPCompression)
+
+		// ReadBytes counts the data as read, WireBytes as sent
+		var payload io.Reader = &progressReader{r: reader, job: job}
+		if compressor != nil {
+			compressed := compressBody(compressor, payload)
+			defer compressed.Close()
+			payload = compressed
+		}
+		payload = &progressReader{r: payload, job: job, wire: true}
+
+		httpReq, err := http.NewRequest(http.MethodPost, endpoint.String(), payload)
+		if err == nil {
+			if compressor != nil {
+				httpReq.Header.Set("Content-Encoding", config.HTTPCompression)
+			}
+			httpReq.Header.Set("X-ClickHouse-User", auth.Username)
+			httpReq.Header.Set("X-ClickHouse-Key", auth.Password)
+			err = job.applySummary(http.DefaultClient.Do(httpReq))
+		}
+		job.finish(err)
+		if err != nil {
+			logger.Error("raw insert failed", "error", err)
+			return
+		}
+		logger.Info("raw insert finished", "duration_ms", time.Since(started).Milliseconds())
+	}()
+
+	snapshot := job.snapshot()
+	return &snapshot, nil
+}
+
+// progressReader counts the bytes passing through into the job's
+// ReadBytes, or its WireBytes when wire is set.
+type progressReader struct {
+	r    io.Reader
+	job  *ingestJob
+	wire bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.job.mu.Lock()
+		if p.wire {
+			p.job.job.WireBytes += uint64(n)
+		} else {
+			p.job.job.ReadBytes += uint64(n)
+		}
+		p.job.mu.Unlock()
+	}
+	return n, err
+}
+
+// applySummary records the X-ClickHouse-Summary of a finished insert, or
+// turns an error response into an error carrying the server's message.
+func (j *ingestJob) applySummary(resp *http.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed to send data to ClickHouse: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ClickHouse rejected the data: %s", strings.TrimSpace(string(body)))
+	}
+	// With wait_end_of_query an exception after the headers ends up in the
+	// body instead of the status code
+	if bytes.Contains(body, []byte("DB::Exception")) {
+		return fmt.Errorf("ClickHouse rejected the data: %s", strings.TrimSpace(string(body)))
+	}
+
+	var summary struct {
+		ReadRows     string `json:"read_rows"`
+		WrittenRows  string `json:"written_rows"`
+		WrittenBytes string `json:"written_bytes"`
+		TotalRows    string `json:"total_rows_to_read"`
+	}
+	if err := json.Unmarshal([]byte(resp.Header.Get("X-ClickHouse-Summary")), &summary); err != nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.job.ReadRows, _ = strconv.ParseUint(summary.ReadRows, 10, 64)
+	j.job.WrittenRows, _ = strconv.ParseUint(summary.WrittenRows, 10, 64)
+	j.job.WrittenBytes, _ = strconv.ParseUint(summary.WrittenBytes, 10, 64)
+	j.job.TotalRowsToRead, _ = strconv.ParseUint(summary.TotalRows, 10, 64)
+	return nil
+}
+
+// validateRawSample parses the start of the stream without consuming it.
+// CSV and TSV samples must have a consistent column count and, when the
+// format carries names and columns are given, a header naming them all.
+// Parquet is checked for its magic bytes. Other formats are left to the
+// server.
+func validateRawSample(r *bufio.Reader, req models.RawImportRequest) error {
+	sample, err := r.Peek(rawSampleSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("data is empty")
+	}
+
+	format := strings.ToLower(req.Format)
+	switch {
+	case format == "parquet":
+		if !bytes.HasPrefix(sample, []byte("PAR1")) {
+			return fmt.Errorf("data is not a Parquet file")
+		}
+		return nil
+	case strings.HasPrefix(format, "csv"), strings.HasPrefix(format, "tsv"), strings.HasPrefix(format, "tabseparated"):
+	default:
+		return nil
+	}
+
+	// Drop a possibly cut-off last line unless the sample is the whole file
+	if len(sample) == rawSampleSize {
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i+1]
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(sample))
+	reader.FieldsPerRecord = 0
+	reader.Comma = ','
+	if req.Delimiter != "" {
+		reader.Comma = rune(req.Delimiter[0])
+	}
+	if !strings.HasPrefix(format, "csv") {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to parse the first line: %v", err)
+	}
+	if strings.Contains(format, "withnames") && len(req.Columns) > 0 {
+		for _, col := range req.Columns {
+			if !containsString(header, col) {
+				return fmt.Errorf("column %s is not in the file header", col)
+			}
+		}
+	}
+
+	for row := 1; row <= rawSampleRows; row++ {
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("sample row %d is malformed: %v", row, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/logging"
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// finishedJobTTL is how long a finished ingestion stays queryable.
+const finishedJobTTL = time.Hour
+
+// ingestJob guards a job's progress, which the query progress callback
+// updates while status requests read it.
+type ingestJob struct {
+	mu  sync.Mutex
+	job models.IngestJob
+}
+
+// StartIngest runs INSERT INTO ... SELECT FROM the source's table function
+// in the background and returns the job to poll. It takes ownership of conn
+// and closes it when the insert ends. fn is the table function and args its
+// bound arguments, as built by SourceService.TableFunction.
+func (s *ClickHouseService) StartIngest(conn driver.Conn, req models.IngestRequest, fn string, args []interface{}, owner string) (*models.IngestJob, error) {
+	if req.Table == "" {
+		conn.Close()
+		return nil, fmt.Errorf("table is required")
+	}
+
+	id, err := newFileID()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	names := make([]string, len(req.Columns))
+	for i, col := range req.Columns {
+		names[i] = quoteIdentifier(col.Name)
+	}
+	columns := strings.Join(names, ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteTableName(req.Table), columns, columns, fn)
+
+	settings := clickhouse.Settings{}
+	if req.Delimiter != "" {
+		settings["format_csv_delimiter"] = req.Delimiter[:1]
+	}
+
+	job := &ingestJob{job: models.IngestJob{
+		ID:        id,
+		QueryID:   id,
+		Status:    models.JobRunning,
+		Table:     req.Table,
+		Source:    describeSource(req.Source),
+		Owner:     owner,
+		StartedAt: time.Now().UTC(),
+	}}
+	s.pruneIngestJobs()
+	s.ingestJobs.Store(id, job)
+
+	ctx := clickhouse.Context(context.Background(),
+		clickhouse.WithQueryID(id),
+		clickhouse.WithSettings(settings),
+		clickhouse.WithProgress(job.addProgress),
+	)
+
+	logger := s.logger.With("job_id", id, "table", req.Table)
+	go func() {
+		defer conn.Close()
+		started := time.Now()
+		logger.Info("ingestion started", "source", logging.URL(describeSource(req.Source)), "query_fingerprint", queryFingerprint(query))
+		err := conn.Exec(ctx, query, args...)
+		job.finish(err)
+		if err != nil {
+			logger.Error("ingestion failed", "error", err)
+			return
+		}
+		logger.Info("ingestion finished", "duration_ms", time.Since(started).Milliseconds())
+	}()
+
+	snapshot := job.snapshot()
+	return &snapshot, nil
+}
+
+// IngestJob returns the progress of an ingestion. An empty owner sees
+// every job.
+func (s *ClickHouseService) IngestJob(id, owner string) (*models.IngestJob, error) {
+	value, ok := s.ingestJobs.Load(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	snapshot := value.(*ingestJob).snapshot()
+	if owner != "" && snapshot.Owner != owner {
+		return nil, ErrJobNotFound
+	}
+	return &snapshot, nil
+}
+
+func (s *ClickHouseService) pruneIngestJobs() {
+	cutoff := time.Now().Add(-finishedJobTTL)
+	s.ingestJobs.Range(func(key, value interface{}) bool {
+		job := value.(*ingestJob).snapshot()
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			s.ingestJobs.Delete(key)
+		}
+		return true
+	})
+}
+
+// addProgress accumulates a progress packet; the server sends increments,
+// not totals.
+func (j *ingestJob) addProgress(p *clickhouse.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.job.ReadRows += p.Rows
+	j.job.ReadBytes += p.Bytes
+	j.job.TotalRowsToRead += p.TotalRows
+	j.job.WrittenRows += p.WroteRows
+	j.job.WrittenBytes += p.WroteBytes
+}
+
+func (j *ingestJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now().UTC()
+	j.job.FinishedAt = &now
+	j.job.Status = models.JobDone
+	if err != nil {
+		j.job.Status = models.JobFailed
+		j.job.Error = err.Error()
+	}
+}
+
+func (j *ingestJob) snapshot() models.IngestJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+func describeSource(src models.ImportSource) string {
+	switch src.Type {
+	case models.SourceURL:
+		return src.URL
+	case models.SourceS3:
+		return fmt.Sprintf("s3://%s/%s", src.Bucket, src.Key)
+	case models.SourceClickHouseFile:
+		return "file:" + src.Path
+	}
+	return src.Type
+}
+
+// IngestStructure renders import columns as a table function structure
+// argument, e.g. "`id` UInt64, `name` String".
+func IngestStructure(columns []models.Column) (string, error) {
+	if len(columns) == 0 {
+		return "", fmt.Errorf("columns are required")
+	}
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		if col.Name == "" || col.Type == "" {
+			return "", fmt.Errorf("column %d needs a name and type", i+1)
+		}
+		parts[i] = quoteIdentifier(col.Name) + " " + col.Type
+	}
+	return strings.Join(parts, ", "), nil
+}
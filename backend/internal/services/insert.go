@@ -0,0 +1,724 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// importBatch is a run of consecutive rows; seq numbers batches in reader
+// order starting at 0.
+type importBatch struct {
+	seq  int
+	rows [][]interface{}
+}
+
+// importPlan is what every insert worker needs to send a batch. query is
+// the INSERT without data and types the target column types.
+type importPlan struct {
+	query    string
+	types    []string
+	columnar bool
+	async    *models.AsyncInsert
+}
+
+// valueConverter turns an imported value into what is bound for a column.
+type valueConverter func(value interface{}) (interface{}, error)
+
+// ImportRows inserts the rows returned by next, which signals the end with
+// io.EOF, into req.Table, creating the table first if needed.
+//
+// The reader cuts rows into batches of the configured batch size and hands
+// them through a bounded channel to a pool of insert workers, each with its
+// own connection; conn serves the first worker and req.Config is used to
+// open the others. At most two batches per worker are held in memory.
+//
+// Ordering: every batch is a single INSERT that keeps the reader's row
+// order, and with one worker batches are sent in reader order too. With
+// more workers batches may be inserted in any order. On failure the
+// remaining batches are dropped, but batches already sent stay inserted.
+//
+// Batches are built column by column with typed slices when every target
+// column has a typed builder, and appended row by row otherwise. The
+// metrics' Wire compares the bytes the workers sent with the uncompressed
+// size of the columns, which is only known for columnar batches.
+//
+// With async insert enabled on the request, or else on the connection,
+// each batch is sent as one INSERT ... VALUES with async_insert, and the
+// metrics' Status tells whether the rows were flushed or only accepted.
+func (s *ClickHouseService) ImportRows(conn driver.Conn, req models.ImportRequest, next func() ([]interface{}, error)) (*models.ImportMetrics, error) {
+	if err := s.CreateTable(conn, req.Table, req.Columns); err != nil {
+		return nil, fmt.Errorf("failed to prepare table: %v", err)
+	}
+
+	types, err := s.insertColumnTypes(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	plan := importPlan{types: types, async: req.Config.AsyncInsert}
+	if req.AsyncInsert != nil {
+		plan.async = req.AsyncInsert
+	}
+	if plan.async != nil && !plan.async.Enabled {
+		plan.async = nil
+	}
+	if plan.async == nil {
+		_, plan.columnar = newColumnBuilders(types)
+	}
+
+	workers := s.config.InsertWorkers
+	if req.Workers > 0 && req.Workers < workers {
+		workers = req.Workers
+	}
+
+	names := make([]string, len(req.Columns))
+	for i, col := range req.Columns {
+		names[i] = quoteIdentifier(col.Name)
+	}
+	plan.query = fmt.Sprintf("INSERT INTO %s (%s)", quoteTableName(req.Table), strings.Join(names, ", "))
+	s.logger.Debug("import started", "table", req.Table, "workers", workers, "columnar", plan.columnar, "async", plan.async != nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		firstErr  error
+		metrics   = &models.ImportMetrics{Status: models.ImportFlushed, Workers: workers, Columnar: plan.columnar}
+		rawBytes  uint64
+		wireBytes uint64
+		wg        sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	started := time.Now()
+	batches := make(chan importBatch, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			workerConn := conn
+			if w > 0 {
+				c, err := s.Connect(req.Config)
+				if err != nil {
+					fail(fmt.Errorf("insert worker %d failed to connect: %v", w, err))
+					return
+				}
+				defer c.Close()
+				workerConn = c
+			}
+
+			_, sentBefore, _ := wireCounts(workerConn)
+			defer func() {
+				_, sentAfter, _ := wireCounts(workerConn)
+				mu.Lock()
+				wireBytes += sentAfter - sentBefore
+				mu.Unlock()
+			}()
+
+			for batch := range batches {
+				if ctx.Err() != nil {
+					continue
+				}
+				raw, err := s.sendImportBatch(ctx, workerConn, plan, batch)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				metrics.Rows += uint64(len(batch.rows))
+				metrics.Batches++
+				rawBytes += raw
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	readErr := s.feedImportBatches(ctx, batches, next)
+	close(batches)
+	wg.Wait()
+
+	if firstErr == nil && readErr != nil {
+		firstErr = readErr
+	}
+	if firstErr != nil {
+		return nil, fmt.Errorf("%v (%d rows in %d batches were inserted before the failure)",
+			firstErr, metrics.Rows, metrics.Batches)
+	}
+
+	elapsed := time.Since(started)
+	metrics.DurationMs = elapsed.Milliseconds()
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		metrics.RowsPerSecond = float64(metrics.Rows) / seconds
+	}
+	if plan.async != nil && !plan.async.Wait {
+		metrics.Status = models.ImportAccepted
+	}
+	if compression, _, _ := wireCounts(conn); compression != "" {
+		metrics.Wire = newWireStats(compression, rawBytes, wireBytes)
+	}
+
+	// Verify the import by counting rows
+	countQuery := fmt.Sprintf("SELECT count() FROM %s", quoteTableName(req.Table))
+	if err := conn.QueryRow(context.Background(), countQuery).Scan(&metrics.TableRows); err != nil {
+		return nil, fmt.Errorf("failed to verify import: %v", err)
+	}
+	s.logger.Debug("import finished", "table", req.Table, "rows", metrics.Rows, "batches", metrics.Batches,
+		"rows_per_second", metrics.RowsPerSecond, "table_rows", metrics.TableRows)
+
+	return metrics, nil
+}
+
+// feedImportBatches reads rows until io.EOF and sends them on in batches.
+// It stops early when ctx is cancelled by a failing worker.
+func (s *ClickHouseService) feedImportBatches(ctx context.Context, batches chan<- importBatch, next func() ([]interface{}, error)) error {
+	seq := 0
+	rows := make([][]interface{}, 0, s.config.BatchSize)
+	send := func() bool {
+		select {
+		case batches <- importBatch{seq: seq, rows: rows}:
+			seq++
+			rows = make([][]interface{}, 0, s.config.BatchSize)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for ctx.Err() == nil {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		if len(rows) == s.config.BatchSize && !send() {
+			return nil
+		}
+	}
+	if len(rows) > 0 && ctx.Err() == nil {
+		send()
+	}
+	return nil
+}
+
+// sendImportBatch inserts one batch and returns the uncompressed size of
+// its columns, or 0 for row by row and async batches. Row numbers in
+// errors count from the start of the file.
+func (s *ClickHouseService) sendImportBatch(ctx context.Context, conn driver.Conn, plan importPlan, batch importBatch) (uint64, error) {
+	if plan.async != nil {
+		return 0, s.sendAsyncBatch(ctx, conn, plan, batch)
+	}
+
+	prepared, err := conn.PrepareBatch(ctx, plan.query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare batch %d: %v", batch.seq+1, err)
+	}
+	firstRow := batch.seq*s.config.BatchSize + 1
+
+	var raw uint64
+	if plan.columnar {
+		builders, _ := newColumnBuilders(plan.types)
+		for i, row := range batch.rows {
+			if len(row) != len(builders) {
+				prepared.Abort()
+				return 0, fmt.Errorf("row %d has %d values, expected %d", firstRow+i, len(row), len(builders))
+			}
+			for j, value := range row {
+				if err := builders[j].add(value); err != nil {
+					prepared.Abort()
+					return 0, fmt.Errorf("row %d, column %d: %v", firstRow+i, j+1, err)
+				}
+			}
+		}
+		for j, builder := range builders {
+			if err := builder.appendTo(prepared.Column(j)); err != nil {
+				prepared.Abort()
+				return 0, fmt.Errorf("failed to append column %d of batch %d: %v", j+1, batch.seq+1, err)
+			}
+			raw += builder.size()
+		}
+	} else {
+		for i, row := range batch.rows {
+			if err := prepared.Append(row...); err != nil {
+				prepared.Abort()
+				return 0, fmt.Errorf("failed to append row %d: %v", firstRow+i, err)
+			}
+		}
+	}
+
+	if err := prepared.Send(); err != nil {
+		return 0, fmt.Errorf("failed to send batch %d: %v", batch.seq+1, err)
+	}
+	return raw, nil
+}
+
+// sendAsyncBatch sends a batch as INSERT ... VALUES with the values bound
+// into the query text, which async_insert buffers on the server. Values
+// are converted as for columnar batches first, so empty cells become NULL
+// and numbers and dates are parsed the same way in both modes.
+func (s *ClickHouseService) sendAsyncBatch(ctx context.Context, conn driver.Conn, plan importPlan, batch importBatch) error {
+	firstRow := batch.seq*s.config.BatchSize + 1
+	group := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(plan.types)), ", ") + ")"
+	converters := newValueConverters(plan.types)
+
+	var sb strings.Builder
+	sb.WriteString(plan.query)
+	sb.WriteString(" VALUES ")
+	args := make([]interface{}, 0, len(batch.rows)*len(plan.types))
+	for i, row := range batch.rows {
+		if len(row) != len(plan.types) {
+			return fmt.Errorf("row %d has %d values, expected %d", firstRow+i, len(row), len(plan.types))
+		}
+		for j, value := range row {
+			converted, err := converters[j](value)
+			if err != nil {
+				return fmt.Errorf("row %d, column %d: %v", firstRow+i, j+1, err)
+			}
+			args = append(args, converted)
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(group)
+	}
+
+	if err := conn.AsyncInsert(ctx, sb.String(), plan.async.Wait, args...); err != nil {
+		return fmt.Errorf("failed to send batch %d: %v", batch.seq+1, err)
+	}
+	return nil
+}
+
+// insertColumnTypes returns the table's actual type for each import column,
+// which may differ from the requested one when the table already existed.
+func (s *ClickHouseService) insertColumnTypes(conn driver.Conn, req models.ImportRequest) ([]string, error) {
+	database := req.Config.Database
+	if database == "" {
+		database = s.config.Database
+	}
+	columns, err := s.GetColumns(conn, database, req.Table)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]string, len(columns))
+	for _, col := range columns {
+		actual[col.Name] = col.Type
+	}
+
+	types := make([]string, len(req.Columns))
+	for i, col := range req.Columns {
+		chType, ok := actual[col.Name]
+		if !ok {
+			return nil, fmt.Errorf("column %s does not exist in table %s", col.Name, req.Table)
+		}
+		types[i] = chType
+	}
+	return types, nil
+}
+
+// newValueConverters returns a converter per type for binding values into
+// a query. Types without a builder pass values through unchanged, as in
+// row by row batches.
+func newValueConverters(types []string) []valueConverter {
+	converters := make([]valueConverter, len(types))
+	for i, chType := range types {
+		info := ParseType(chType)
+		builder := newColumnBuilder(info)
+		switch {
+		case builder == nil:
+			converters[i] = func(value interface{}) (interface{}, error) { return value, nil }
+		case info.Name == "DateTime64":
+			// Bound times lose their fraction, so DateTime64 values are
+			// sent as ticks of the column's precision, which ClickHouse
+			// reads as a Unix timestamp
+			precision, err := strconv.Atoi(typeAt(info.Params, 0))
+			if err != nil || precision < 0 || precision > 9 {
+				precision = 3
+			}
+			scale := int64(math.Pow10(9 - precision))
+			converters[i] = func(value interface{}) (interface{}, error) {
+				converted, err := builder.convert(value)
+				if t, ok := converted.(time.Time); ok {
+					return t.UnixNano() / scale, err
+				}
+				return converted, err
+			}
+		default:
+			converters[i] = builder.convert
+		}
+	}
+	return converters
+}
+
+// columnBuilder collects one column of a batch as a typed slice and hands it
+// to the driver in a single BatchColumn.Append. size is the column's length
+// in the native format before compression. convert parses a single value
+// the same way add does, returning nil for NULL.
+type columnBuilder interface {
+	add(value interface{}) error
+	appendTo(column driver.BatchColumn) error
+	size() uint64
+	convert(value interface{}) (interface{}, error)
+}
+
+// newColumnBuilders returns a builder per type, or false when a type has
+// none and the batch must be appended row by row.
+func newColumnBuilders(types []string) ([]columnBuilder, bool) {
+	builders := make([]columnBuilder, len(types))
+	for i, chType := range types {
+		builder := newColumnBuilder(ParseType(chType))
+		if builder == nil {
+			return nil, false
+		}
+		builders[i] = builder
+	}
+	return builders, true
+}
+
+// newColumnBuilder picks the slice type the driver's column accepts for
+// info, e.g. []int32 for Int32 and []*int32 for Nullable(Int32).
+func newColumnBuilder(info models.TypeInfo) columnBuilder {
+	switch info.Name {
+	case "Int8":
+		return newTypedColumn(info, intParser[int8](8))
+	case "Int16":
+		return newTypedColumn(info, intParser[int16](16))
+	case "Int32":
+		return newTypedColumn(info, intParser[int32](32))
+	case "Int64":
+		return newTypedColumn(info, intParser[int64](64))
+	case "UInt8":
+		return newTypedColumn(info, uintParser[uint8](8))
+	case "UInt16":
+		return newTypedColumn(info, uintParser[uint16](16))
+	case "UInt32":
+		return newTypedColumn(info, uintParser[uint32](32))
+	case "UInt64":
+		return newTypedColumn(info, uintParser[uint64](64))
+	case "Float32":
+		return newTypedColumn(info, func(v interface{}) (float32, error) {
+			f, err := toFloat64(v, 32)
+			return float32(f), err
+		})
+	case "Float64":
+		return newTypedColumn(info, func(v interface{}) (float64, error) { return toFloat64(v, 64) })
+	case "Bool":
+		return newTypedColumn(info, toBool)
+	case "String", "FixedString", "Enum8", "Enum16":
+		return newTypedColumn(info, toString)
+	case "Date", "Date32", "DateTime", "DateTime64":
+		return newTypedColumn(info, toTime)
+	}
+	return nil
+}
+
+// newTypedColumn returns a builder producing []T, or []*T for Nullable
+// columns. LowCardinality columns get boxed values instead, because the
+// driver deduplicates their dictionary by value and would treat every
+// pointer as a distinct entry.
+func newTypedColumn[T any](info models.TypeInfo, parse func(interface{}) (T, error)) columnBuilder {
+	// String columns keep empty values; other types read them as NULL
+	emptyIsNull := info.Nullable && info.Name != "String" && info.Name != "FixedString"
+	sizes := columnSize{width: nativeWidth(info), nullable: info.Nullable}
+	if info.LowCardinality {
+		return &boxedColumn{columnSize: sizes, emptyIsNull: emptyIsNull, parse: func(v interface{}) (interface{}, error) {
+			t, err := parse(v)
+			return t, err
+		}}
+	}
+	return &typedColumn[T]{columnSize: sizes, emptyIsNull: emptyIsNull, parse: parse}
+}
+
+type typedColumn[T any] struct {
+	columnSize
+	emptyIsNull bool
+	parse       func(interface{}) (T, error)
+	values      []T
+	pointers    []*T
+}
+
+func (c *typedColumn[T]) add(value interface{}) error {
+	if isNullValue(value, c.emptyIsNull) {
+		if !c.nullable {
+			return fmt.Errorf("NULL in a non-Nullable column")
+		}
+		c.pointers = append(c.pointers, nil)
+		c.count(nil)
+		return nil
+	}
+	v, err := c.parse(value)
+	if err != nil {
+		return err
+	}
+	if c.nullable {
+		c.pointers = append(c.pointers, &v)
+	} else {
+		c.values = append(c.values, v)
+	}
+	c.count(v)
+	return nil
+}
+
+func (c *typedColumn[T]) convert(value interface{}) (interface{}, error) {
+	if isNullValue(value, c.emptyIsNull) {
+		if !c.nullable {
+			return nil, fmt.Errorf("NULL in a non-Nullable column")
+		}
+		return nil, nil
+	}
+	v, err := c.parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c *typedColumn[T]) appendTo(column driver.BatchColumn) error {
+	if c.nullable {
+		return column.Append(c.pointers)
+	}
+	return column.Append(c.values)
+}
+
+type boxedColumn struct {
+	columnSize
+	emptyIsNull bool
+	parse       func(interface{}) (interface{}, error)
+	values      []interface{}
+}
+
+func (c *boxedColumn) add(value interface{}) error {
+	if isNullValue(value, c.emptyIsNull) {
+		if !c.nullable {
+			return fmt.Errorf("NULL in a non-Nullable column")
+		}
+		c.values = append(c.values, nil)
+		c.count(nil)
+		return nil
+	}
+	v, err := c.parse(value)
+	if err != nil {
+		return err
+	}
+	c.values = append(c.values, v)
+	c.count(v)
+	return nil
+}
+
+func (c *boxedColumn) convert(value interface{}) (interface{}, error) {
+	if isNullValue(value, c.emptyIsNull) {
+		if !c.nullable {
+			return nil, fmt.Errorf("NULL in a non-Nullable column")
+		}
+		return nil, nil
+	}
+	return c.parse(value)
+}
+
+func (c *boxedColumn) appendTo(column driver.BatchColumn) error {
+	return column.Append(c.values)
+}
+
+// columnSize adds up a column's native encoding: width bytes per value,
+// or a length prefix and the bytes for strings, plus a null map byte per
+// value of a Nullable column. LowCardinality columns are counted as their
+// plain type.
+type columnSize struct {
+	width    int
+	nullable bool
+	bytes    uint64
+}
+
+func (c *columnSize) count(value interface{}) {
+	if c.nullable {
+		c.bytes++
+	}
+	if c.width > 0 {
+		c.bytes += uint64(c.width)
+		return
+	}
+	s, _ := value.(string)
+	var prefix [binary.MaxVarintLen64]byte
+	c.bytes += uint64(binary.PutUvarint(prefix[:], uint64(len(s))) + len(s))
+}
+
+func (c *columnSize) size() uint64 {
+	return c.bytes
+}
+
+// nativeWidth is the fixed size of a value in the native format, or 0 for
+// length-prefixed strings.
+func nativeWidth(info models.TypeInfo) int {
+	switch info.Name {
+	case "Int8", "UInt8", "Bool", "Enum8":
+		return 1
+	case "Int16", "UInt16", "Date", "Enum16":
+		return 2
+	case "Int32", "UInt32", "Float32", "Date32", "DateTime":
+		return 4
+	case "Int64", "UInt64", "Float64", "DateTime64":
+		return 8
+	case "FixedString":
+		n, _ := strconv.Atoi(typeAt(info.Params, 0))
+		return n
+	}
+	return 0
+}
+
+func isNullValue(value interface{}, emptyIsNull bool) bool {
+	if value == nil {
+		return true
+	}
+	s, ok := value.(string)
+	return ok && emptyIsNull && s == ""
+}
+
+func intParser[T int8 | int16 | int32 | int64](bits int) func(interface{}) (T, error) {
+	return func(v interface{}) (T, error) {
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case int:
+			n = int64(x)
+		case float64:
+			if x != math.Trunc(x) {
+				return 0, fmt.Errorf("%v is not an integer", x)
+			}
+			n = int64(x)
+		case string:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid integer %q", x)
+			}
+			n = parsed
+		default:
+			return 0, fmt.Errorf("cannot convert %T to an integer", v)
+		}
+		if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+			return 0, fmt.Errorf("%d is out of range for Int%d", n, bits)
+		}
+		return T(n), nil
+	}
+}
+
+func uintParser[T uint8 | uint16 | uint32 | uint64](bits int) func(interface{}) (T, error) {
+	return func(v interface{}) (T, error) {
+		var n uint64
+		switch x := v.(type) {
+		case int64:
+			if x < 0 {
+				return 0, fmt.Errorf("%d is out of range for UInt%d", x, bits)
+			}
+			n = uint64(x)
+		case int:
+			if x < 0 {
+				return 0, fmt.Errorf("%d is out of range for UInt%d", x, bits)
+			}
+			n = uint64(x)
+		case float64:
+			if x < 0 || x != math.Trunc(x) {
+				return 0, fmt.Errorf("%v is not an unsigned integer", x)
+			}
+			n = uint64(x)
+		case string:
+			parsed, err := strconv.ParseUint(strings.TrimSpace(x), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid unsigned integer %q", x)
+			}
+			n = parsed
+		default:
+			return 0, fmt.Errorf("cannot convert %T to an unsigned integer", v)
+		}
+		if bits < 64 && n >= 1<<bits {
+			return 0, fmt.Errorf("%d is out of range for UInt%d", n, bits)
+		}
+		return T(n), nil
+	}
+}
+
+func toFloat64(v interface{}, bits int) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case int64:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), bits)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", x)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case int64:
+		return x != 0, nil
+	case float64:
+		return x != 0, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(x))
+		if err != nil {
+			return false, fmt.Errorf("invalid boolean %q", x)
+		}
+		return b, nil
+	}
+	return false, fmt.Errorf("cannot convert %T to a boolean", v)
+}
+
+func toString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// importTimeLayouts are tried in order for date and time strings.
+var importTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		x = strings.TrimSpace(x)
+		for _, layout := range importTimeLayouts {
+			if t, err := time.Parse(layout, x); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid date or time %q", x)
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to a date or time", v)
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// recordingConn captures what the insert paths send instead of talking to
+// a server. Methods the tests do not use panic through the nil embedded
+// interface.
+type recordingConn struct {
+	driver.Conn
+	batch      *recordingBatch
+	asyncQuery string
+	asyncArgs  []interface{}
+}
+
+func (c *recordingConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	c.batch = &recordingBatch{columns: map[int]*recordingColumn{}}
+	return c.batch, nil
+}
+
+func (c *recordingConn) AsyncInsert(ctx context.Context, query string, wait bool, args ...any) error {
+	c.asyncQuery = query
+	c.asyncArgs = args
+	return nil
+}
+
+type recordingBatch struct {
+	driver.Batch
+	columns map[int]*recordingColumn
+	rows    [][]interface{}
+	aborted bool
+	sent    bool
+}
+
+func (b *recordingBatch) Column(i int) driver.BatchColumn {
+	if b.columns[i] == nil {
+		b.columns[i] = &recordingColumn{}
+	}
+	return b.columns[i]
+}
+
+func (b *recordingBatch) Append(v ...any) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *recordingBatch) Abort() error {
+	b.aborted = true
+	return nil
+}
+
+func (b *recordingBatch) Send() error {
+	b.sent = true
+	return nil
+}
+
+type recordingColumn struct {
+	driver.BatchColumn
+	values interface{}
+}
+
+func (c *recordingColumn) Append(v any) error {
+	c.values = v
+	return nil
+}
+
+func newTestInsertService(batchSize int) *ClickHouseService {
+	return &ClickHouseService{config: config.ClickHouseConfig{BatchSize: batchSize}}
+}
+
+func TestEmptyNullableCellImportsAsNull(t *testing.T) {
+	s := newTestInsertService(10)
+	batch := importBatch{rows: [][]interface{}{{"5"}, {""}}}
+	plan := importPlan{query: "INSERT INTO `t` (`n`)", types: []string{"Nullable(Int64)"}}
+
+	t.Run("columnar", func(t *testing.T) {
+		conn := &recordingConn{}
+		plan := plan
+		plan.columnar = true
+		if _, err := s.sendImportBatch(context.Background(), conn, plan, batch); err != nil {
+			t.Fatal(err)
+		}
+		values, ok := conn.batch.columns[0].values.([]*int64)
+		if !ok {
+			t.Fatalf("column got %T, want []*int64", conn.batch.columns[0].values)
+		}
+		if len(values) != 2 || values[0] == nil || *values[0] != 5 || values[1] != nil {
+			t.Errorf("column got %v, want [5 NULL]", values)
+		}
+		if !conn.batch.sent {
+			t.Error("batch was not sent")
+		}
+	})
+
+	t.Run("async", func(t *testing.T) {
+		conn := &recordingConn{}
+		plan := plan
+		plan.async = &models.AsyncInsert{Enabled: true}
+		if _, err := s.sendImportBatch(context.Background(), conn, plan, batch); err != nil {
+			t.Fatal(err)
+		}
+		if want := "INSERT INTO `t` (`n`) VALUES (?), (?)"; conn.asyncQuery != want {
+			t.Errorf("query got %q, want %q", conn.asyncQuery, want)
+		}
+		if len(conn.asyncArgs) != 2 || conn.asyncArgs[0] != int64(5) || conn.asyncArgs[1] != nil {
+			t.Errorf("args got %#v, want [5 <nil>]", conn.asyncArgs)
+		}
+	})
+}
+
+func TestAsyncValuesAreParsedLikeColumnar(t *testing.T) {
+	converters := newValueConverters([]string{"UInt8", "DateTime64(3, 'UTC')", "Decimal(18, 4)", "String"})
+
+	tests := []struct {
+		column int
+		value  interface{}
+		want   interface{}
+	}{
+		{0, " 42 ", uint8(42)},
+		{1, "2024-01-02 03:04:05.678", int64(1704164645678)},
+		{2, "12.3400", "12.3400"},
+		{3, "", ""},
+	}
+	for _, tt := range tests {
+		got, err := converters[tt.column](tt.value)
+		if err != nil {
+			t.Errorf("column %d, %q: %v", tt.column, tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("column %d, %q: got %#v, want %#v", tt.column, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := converters[0]("300"); err == nil {
+		t.Error("300 for UInt8: want an error")
+	}
+	if _, err := converters[0](""); err == nil {
+		t.Error("empty cell for non-Nullable UInt8: want an error")
+	}
+}
+
+func TestColumnBuildersParseValues(t *testing.T) {
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	dateTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		chType string
+		value  interface{}
+		want   interface{}
+	}{
+		{"Date", "2024-01-02", date},
+		{"Date32", " 2024-01-02 ", date},
+		{"DateTime", "2024-01-02 03:04:05", dateTime},
+		{"DateTime", "2024-01-02T03:04:05Z", dateTime},
+		{"DateTime64(3)", "2024-01-02 03:04:05.678", dateTime.Add(678 * time.Millisecond)},
+		{"Nullable(Date)", "", nil},
+		{"Nullable(DateTime)", nil, nil},
+		{"Nullable(Float64)", "", nil},
+		{"Nullable(Float64)", "1.5", 1.5},
+		{"Int16", "-300", int16(-300)},
+		{"Bool", "true", true},
+		{"String", "", ""},
+		{"Nullable(String)", "", ""},
+		{"LowCardinality(Nullable(String))", "x", "x"},
+	}
+	for _, tt := range tests {
+		builder := newColumnBuilder(ParseType(tt.chType))
+		got, err := builder.convert(tt.value)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.chType, tt.value, err)
+			continue
+		}
+		if gotTime, ok := got.(time.Time); ok {
+			if !gotTime.Equal(tt.want.(time.Time)) {
+				t.Errorf("%s %q: got %v, want %v", tt.chType, tt.value, gotTime, tt.want)
+			}
+		} else if got != tt.want {
+			t.Errorf("%s %q: got %#v, want %#v", tt.chType, tt.value, got, tt.want)
+		}
+		if err := builder.add(tt.value); err != nil {
+			t.Errorf("%s %q: add: %v", tt.chType, tt.value, err)
+		}
+	}
+
+	invalid := map[string]interface{}{
+		"Date":     "02/01/2024",
+		"DateTime": "",
+		"Int8":     "128",
+		"UInt32":   "-1",
+		"Float32":  "abc",
+		"Bool":     "maybe",
+	}
+	for chType, value := range invalid {
+		if err := newColumnBuilder(ParseType(chType)).add(value); err == nil {
+			t.Errorf("%s %q: want an error", chType, value)
+		}
+	}
+}
+
+func TestDecimalColumnsAreAppendedRowByRow(t *testing.T) {
+	if _, ok := newColumnBuilders([]string{"Int32", "Decimal(18, 4)"}); ok {
+		t.Fatal("Decimal has no builder, want a row by row batch")
+	}
+
+	s := newTestInsertService(10)
+	conn := &recordingConn{}
+	plan := importPlan{query: "INSERT INTO `t` (`n`, `d`)", types: []string{"Int32", "Decimal(18, 4)"}}
+	batch := importBatch{rows: [][]interface{}{{"1", "12.3400"}}}
+	if _, err := s.sendImportBatch(context.Background(), conn, plan, batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.batch.rows) != 1 || conn.batch.rows[0][1] != "12.3400" {
+		t.Errorf("rows got %v, want the decimal text passed to the driver", conn.batch.rows)
+	}
+}
+
+func TestImportErrorsNumberRowsFromFileStart(t *testing.T) {
+	s := newTestInsertService(10)
+	// The third batch read, as a parallel worker may send it first
+	batch := importBatch{seq: 2, rows: [][]interface{}{{"1"}, {"x"}}}
+	plan := importPlan{query: "INSERT INTO `t` (`n`)", types: []string{"Int64"}}
+
+	t.Run("columnar", func(t *testing.T) {
+		conn := &recordingConn{}
+		plan := plan
+		plan.columnar = true
+		_, err := s.sendImportBatch(context.Background(), conn, plan, batch)
+		if err == nil || !strings.HasPrefix(err.Error(), "row 22, column 1:") {
+			t.Errorf("got %v, want an error for row 22", err)
+		}
+		if !conn.batch.aborted {
+			t.Error("batch was not aborted")
+		}
+	})
+
+	t.Run("async", func(t *testing.T) {
+		plan := plan
+		plan.async = &models.AsyncInsert{Enabled: true}
+		_, err := s.sendImportBatch(context.Background(), &recordingConn{}, plan, batch)
+		if err == nil || !strings.HasPrefix(err.Error(), "row 22, column 1:") {
+			t.Errorf("got %v, want an error for row 22", err)
+		}
+	})
+
+	t.Run("short row", func(t *testing.T) {
+		plan := plan
+		plan.columnar = true
+		short := importBatch{seq: 1, rows: [][]interface{}{{"1"}, {}}}
+		_, err := s.sendImportBatch(context.Background(), &recordingConn{}, plan, short)
+		if err == nil || !strings.HasPrefix(err.Error(), "row 12 has 0 values") {
+			t.Errorf("got %v, want an error for row 12", err)
+		}
+	})
+}
+
+func TestFeedImportBatchesNumbersBatches(t *testing.T) {
+	s := newTestInsertService(10)
+	batches := make(chan importBatch, 10)
+	read := 0
+	next := func() ([]interface{}, error) {
+		if read == 25 {
+			return nil, io.EOF
+		}
+		read++
+		return []interface{}{read}, nil
+	}
+	if err := s.feedImportBatches(context.Background(), batches, next); err != nil {
+		t.Fatal(err)
+	}
+	close(batches)
+
+	var got []string
+	for batch := range batches {
+		firstRow := batch.seq*s.config.BatchSize + 1
+		if batch.rows[0][0] != firstRow {
+			t.Errorf("batch %d starts with row %v, want %d", batch.seq, batch.rows[0][0], firstRow)
+		}
+		got = append(got, fmt.Sprintf("%d:%d", batch.seq, len(batch.rows)))
+	}
+	if want := "0:10 1:10 2:5"; strings.Join(got, " ") != want {
+		t.Errorf("batches got %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+// storedFile is a listed file together with the paths that make it up on
+// disk, so the janitor can remove an upload and its metadata together.
+type storedFile struct {
+	models.StoredFile
+	paths []string
+}
+
+// RunJanitor sweeps the storage directories every interval until ctx is
+// cancelled. Retention then no longer depends on clients calling cleanup.
+func (s *FileService) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.sweepAndReport()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *FileService) sweepAndReport() {
+	removed, freed, err := s.Sweep(time.Now())
+	if err != nil {
+		s.logger.Error("storage janitor failed", "error", err)
+		return
+	}
+	if removed > 0 {
+		s.logger.Info("storage janitor removed files", "files", removed, "bytes", freed)
+	}
+}
+
+// Sweep deletes expired uploads and exports, then deletes the oldest
+// remaining files until the total size fits MaxDiskUsage.
+func (s *FileService) Sweep(now time.Time) (int, int64, error) {
+	files, err := s.storedFiles(now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+
+	removed, freed := 0, int64(0)
+	remove := func(f storedFile) {
+		for _, path := range f.paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				s.logger.Warn("storage janitor could not remove file", "path", path, "error", err)
+			}
+		}
+		removed++
+		freed += f.Size
+		total -= f.Size
+	}
+
+	// files is sorted oldest first, so the quota pass evicts by age
+	var kept []storedFile
+	for _, f := range files {
+		if now.After(f.ExpiresAt) {
+			remove(f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	for _, f := range kept {
+		if s.MaxDiskUsage <= 0 || total <= s.MaxDiskUsage {
+			break
+		}
+		// Evicting an upload in progress would only make the client
+		// start over; it is removed once it goes idle instead
+		if f.Kind == models.StoredFilePartial {
+			continue
+		}
+		remove(f)
+	}
+
+	return removed, freed, nil
+}
+
+// ListFiles reports every stored upload and export, oldest first.
+func (s *FileService) ListFiles() (*models.StorageUsage, error) {
+	files, err := s.storedFiles(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &models.StorageUsage{Files: []models.StoredFile{}, MaxDiskUsage: s.MaxDiskUsage}
+	for _, f := range files {
+		usage.Files = append(usage.Files, f.StoredFile)
+		usage.TotalSize += f.Size
+	}
+	return usage, nil
+}
+
+func (s *FileService) storedFiles(now time.Time) ([]storedFile, error) {
+	uploads, err := s.storedUploads()
+	if err != nil {
+		return nil, err
+	}
+	exports, err := s.storedExports()
+	if err != nil {
+		return nil, err
+	}
+
+	files := append(uploads, exports...)
+	for i := range files {
+		files[i].AgeSeconds = int64(now.Sub(files[i].CreatedAt).Seconds())
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt.Before(files[j].CreatedAt) })
+	return files, nil
+}
+
+func (s *FileService) storedUploads() ([]storedFile, error) {
+	entries, err := os.ReadDir(s.UploadDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %v", err)
+	}
+
+	var files []storedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		id, ext, _ := strings.Cut(name, ".")
+
+		if fileIDPattern.MatchString(id) && (ext == "part" || ext == "session.json") {
+			if ext == "part" {
+				if _, err := os.Stat(s.uploadSessionPath(id)); err == nil {
+					continue
+				}
+			} else if session, err := s.GetUploadSession(id, ""); err == nil {
+				files = append(files, storedFile{
+					StoredFile: models.StoredFile{
+						Kind:      models.StoredFilePartial,
+						ID:        session.ID,
+						Name:      session.Name,
+						Size:      session.Offset,
+						Owner:     session.Owner,
+						CreatedAt: session.CreatedAt,
+						// Idle sessions expire; active ones stay alive
+						ExpiresAt: session.UpdatedAt.Add(s.UploadTTL),
+					},
+					paths: []string{s.uploadPartPath(id), s.uploadSessionPath(id)},
+				})
+				continue
+			}
+		}
+
+		if fileIDPattern.MatchString(id) && (ext == "json" || ext == "data") {
+			if ext == "data" {
+				// Listed through its metadata record below
+				if _, err := os.Stat(s.uploadMetaPath(id)); err == nil {
+					continue
+				}
+			} else if upload, err := s.readUploadMeta(id); err == nil {
+				files = append(files, storedFile{
+					StoredFile: models.StoredFile{
+						Kind:      models.StoredFileUpload,
+						ID:        upload.ID,
+						Name:      upload.Name,
+						Size:      upload.Size,
+						Owner:     upload.Owner,
+						CreatedAt: upload.CreatedAt,
+						ExpiresAt: upload.CreatedAt.Add(s.UploadTTL),
+					},
+					paths: []string{s.uploadDataPath(id), s.uploadMetaPath(id)},
+				})
+				continue
+			}
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, storedFile{
+			StoredFile: models.StoredFile{
+				Kind:      models.StoredFileOrphan,
+				Name:      name,
+				Size:      info.Size(),
+				CreatedAt: info.ModTime(),
+				ExpiresAt: info.ModTime().Add(s.UploadTTL),
+			},
+			paths: []string{filepath.Join(s.UploadDir, name)},
+		})
+	}
+	return files, nil
+}
+
+func (s *FileService) storedExports() ([]storedFile, error) {
+	entries, err := os.ReadDir(s.ExportDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %v", err)
+	}
+
+	var files []storedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		id := strings.TrimSuffix(entry.Name(), ext)
+		path := filepath.Join(s.ExportDir, entry.Name())
+
+		validID := fileIDPattern.MatchString(id)
+		if validID && ext == ".json" {
+			// Listed with its data file
+			if _, ok := s.exportDataPath(id); ok {
+				continue
+			}
+		}
+
+		export, err := s.statExport(id, path)
+		if err != nil {
+			continue
+		}
+		kind := models.StoredFileExport
+		paths := []string{path, s.exportMetaPath(id)}
+		if !validID || ext == ".json" {
+			kind = models.StoredFileOrphan
+			export.ID, export.Name, export.Owner = "", entry.Name(), ""
+			paths = []string{path}
+		}
+		files = append(files, storedFile{
+			StoredFile: models.StoredFile{
+				Kind:      kind,
+				ID:        export.ID,
+				Name:      export.Name,
+				Size:      export.Size,
+				Owner:     export.Owner,
+				CreatedAt: export.CreatedAt,
+				ExpiresAt: export.ExpiresAt,
+			},
+			paths: paths,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	DefaultPreviewLimit = 100
+	MaxPreviewLimit     = 1000
+)
+
+// previewCursor is the decoded form of PreviewResult.NextCursor. The
+// fingerprint ties a cursor to the query it was issued for.
+type previewCursor struct {
+	Offset      uint64 `json:"o"`
+	Fingerprint string `json:"f"`
+}
+
+// PreviewData returns one page of a table or custom query. This is offset
+// paging: the cursor only carries the next OFFSET, so pages are not stable.
+// Table previews are ordered by the sorting key, which need not be unique,
+// and custom queries are not ordered at all, so rows tied on the key or
+// inserted and merged between requests may repeat or be skipped across
+// pages. It is meant for browsing, not for reading a table completely.
+func (s *ClickHouseService) PreviewData(conn driver.Conn, req models.PreviewRequest) (*models.PreviewResult, error) {
+	if (req.Table == "") == (req.Query == "") {
+		return nil, fmt.Errorf("exactly one of table or query is required")
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPreviewLimit
+	}
+	if limit > MaxPreviewLimit {
+		limit = MaxPreviewLimit
+	}
+
+	base, err := s.previewBaseQuery(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := queryFingerprint(base)
+
+	var offset uint64
+	if req.Cursor != "" {
+		cursor, err := decodePreviewCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Fingerprint != fingerprint {
+			return nil, fmt.Errorf("cursor does not belong to this preview")
+		}
+		offset = cursor.Offset
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	query := fmt.Sprintf("%s LIMIT %d OFFSET %d", base, limit+1, offset)
+	s.logger.Debug("running preview query", "query_fingerprint", queryFingerprint(query))
+
+	data, err := s.queryReadOnly(conn, query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.PreviewResult{
+		Columns: data.Columns,
+		Rows:    data.Rows,
+	}
+	if len(result.Rows) > limit {
+		result.Rows = result.Rows[:limit]
+		result.NextCursor = encodePreviewCursor(previewCursor{
+			Offset:      offset + uint64(limit),
+			Fingerprint: fingerprint,
+		})
+	}
+
+	if req.Table != "" {
+		if total, err := s.approximateRowCount(conn, req.Config.Database, req.Table); err == nil {
+			result.ApproxTotalRows = &total
+		}
+	}
+
+	return result, nil
+}
+
+func (s *ClickHouseService) previewBaseQuery(conn driver.Conn, req models.PreviewRequest) (string, error) {
+	if req.Query != "" {
+		if err := ValidateReadOnlyQuery(req.Query); err != nil {
+			return "", err
+		}
+		query := strings.TrimRight(strings.TrimSpace(req.Query), ";")
+		// The newline keeps a trailing line comment from swallowing the ")"
+		return fmt.Sprintf("SELECT * FROM (%s\n)", query), nil
+	}
+
+	query := buildSelectQuery(req.Table, req.Columns)
+	sortingKey, err := s.sortingKey(conn, req.Config.Database, req.Table)
+	if err != nil {
+		return "", err
+	}
+	if sortingKey != "" {
+		// The key comes from system.tables, not from the request
+		query += " ORDER BY " + sortingKey
+	}
+	return query, nil
+}
+
+func (s *ClickHouseService) sortingKey(conn driver.Conn, database, table string) (string, error) {
+	database, table = splitTableName(database, table)
+
+	rows, err := conn.Query(context.Background(),
+		"SELECT sorting_key FROM system.tables WHERE database = ? AND name = ?", database, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to query sorting key: %v", err)
+	}
+	defer rows.Close()
+
+	var key string
+	if rows.Next() {
+		if err := rows.Scan(&key); err != nil {
+			return "", fmt.Errorf("failed to scan sorting key: %v", err)
+		}
+	}
+	return key, rows.Err()
+}
+
+// approximateRowCount sums the rows of the table's active parts, which is
+// cheap but ignores rows not yet merged away by ReplacingMergeTree and the
+// like.
+func (s *ClickHouseService) approximateRowCount(conn driver.Conn, database, table string) (uint64, error) {
+	database, table = splitTableName(database, table)
+
+	rows, err := conn.Query(context.Background(),
+		"SELECT sum(rows) FROM system.parts WHERE active AND database = ? AND table = ?", database, table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query row count: %v", err)
+	}
+	defer rows.Close()
+
+	var count uint64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to scan row count: %v", err)
+		}
+	}
+	return count, rows.Err()
+}
+
+// splitTableName resolves a possibly qualified database.table name against
+// the default database.
+func splitTableName(database, table string) (string, string) {
+	if db, name, found := strings.Cut(table, "."); found {
+		return db, name
+	}
+	return database, table
+}
+
+func queryFingerprint(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
+
+func encodePreviewCursor(cursor previewCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePreviewCursor(s string) (previewCursor, error) {
+	var cursor previewCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+	return cursor, nil
+}
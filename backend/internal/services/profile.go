@@ -0,0 +1,331 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+var (
+	ErrProfileNotFound         = errors.New("connection profile not found")
+	ErrProfileExists           = errors.New("connection profile already exists")
+	ErrProfilePasswordRequired = errors.New("password is required when the host or port changes")
+
+	profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// ProfileStore keeps named connection profiles in a JSON file. Passwords
+// are sealed with AES-GCM under the server key and never written in clear
+// text.
+type ProfileStore struct {
+	path string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// storedProfile is the on-disk form of a profile; Config has its secrets
+// cleared and Secrets holds them encrypted.
+type storedProfile struct {
+	Name      string                  `json:"name"`
+	Config    models.ClickHouseConfig `json:"config"`
+	Secrets   string                  `json:"secrets,omitempty"`
+	CreatedAt time.Time               `json:"createdAt"`
+	UpdatedAt time.Time               `json:"updatedAt"`
+}
+
+type profileSecrets struct {
+	Password string `json:"password,omitempty"`
+}
+
+// NewProfileStore opens the store at path. key may be a base64-encoded
+// 32-byte key or any passphrase, which is hashed to a key.
+func NewProfileStore(path string, key string) (*ProfileStore, error) {
+	if key == "" {
+		return nil, fmt.Errorf("profile encryption key is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %v", err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		sum := sha256.Sum256([]byte(key))
+		raw = sum[:]
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile encryption key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile encryption key: %v", err)
+	}
+
+	return &ProfileStore{path: path, aead: aead}, nil
+}
+
+// List returns every profile with its secrets removed.
+func (s *ProfileStore) List() ([]models.ConnectionProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]models.ConnectionProfile, 0, len(all))
+	for _, stored := range all {
+		profiles = append(profiles, stored.redacted())
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+// Get returns a profile with its secrets decrypted, for connecting.
+func (s *ProfileStore) Get(name string) (*models.ConnectionProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	stored, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+
+	profile := stored.redacted()
+	if stored.Secrets != "" {
+		secrets, err := s.open(stored.Secrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt profile %s: %v", name, err)
+		}
+		profile.Config.Password = secrets.Password
+	}
+	return &profile, nil
+}
+
+// GetRedacted returns a profile without its secrets, for display.
+func (s *ProfileStore) GetRedacted(name string) (*models.ConnectionProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	stored, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	profile := stored.redacted()
+	return &profile, nil
+}
+
+// Create adds a new profile and fails if the name is taken.
+func (s *ProfileStore) Create(profile models.ConnectionProfile) (*models.ConnectionProfile, error) {
+	return s.put(profile, true)
+}
+
+// Update replaces an existing profile. An empty password keeps the stored
+// one, so clients can edit a profile without re-entering it, but only while
+// the host and port stay the same; ClearSecrets drops it.
+func (s *ProfileStore) Update(profile models.ConnectionProfile) (*models.ConnectionProfile, error) {
+	return s.put(profile, false)
+}
+
+func (s *ProfileStore) put(profile models.ConnectionProfile, create bool) (*models.ConnectionProfile, error) {
+	if !profileNamePattern.MatchString(profile.Name) {
+		return nil, fmt.Errorf("invalid profile name %q", profile.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, exists := all[profile.Name]
+	switch {
+	case create && exists:
+		return nil, fmt.Errorf("%w: %s", ErrProfileExists, profile.Name)
+	case !create && !exists:
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, profile.Name)
+	}
+
+	secrets := profileSecrets{Password: profile.Config.Password}
+	if exists && existing.Secrets != "" && secrets.Password == "" && !profile.ClearSecrets {
+		if profile.Config.Host != existing.Config.Host || profile.Config.Port != existing.Config.Port {
+			return nil, fmt.Errorf("%w: %s", ErrProfilePasswordRequired, profile.Name)
+		}
+		previous, err := s.open(existing.Secrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt profile %s: %v", profile.Name, err)
+		}
+		secrets.Password = previous.Password
+	}
+
+	now := time.Now().UTC()
+	stored := storedProfile{
+		Name:      profile.Name,
+		Config:    profile.Config,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if exists {
+		stored.CreatedAt = existing.CreatedAt
+	}
+	stored.Config.Profile = ""
+	stored.Config.Password = ""
+	if secrets != (profileSecrets{}) {
+		if stored.Secrets, err = s.seal(secrets); err != nil {
+			return nil, err
+		}
+	}
+
+	all[profile.Name] = stored
+	if err := s.save(all); err != nil {
+		return nil, err
+	}
+
+	result := stored.redacted()
+	return &result, nil
+}
+
+func (s *ProfileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	delete(all, name)
+	return s.save(all)
+}
+
+func (s *ProfileStore) seal(secrets profileSecrets) (string, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode secrets: %v", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *ProfileStore) open(encoded string) (profileSecrets, error) {
+	var secrets profileSecrets
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return secrets, err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return secrets, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return secrets, fmt.Errorf("wrong key or corrupted data")
+	}
+
+	err = json.Unmarshal(plaintext, &secrets)
+	return secrets, err
+}
+
+func (s *ProfileStore) load() (map[string]storedProfile, error) {
+	all := make(map[string]storedProfile)
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %v", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to decode profiles: %v", err)
+	}
+	return all, nil
+}
+
+func (s *ProfileStore) save(all map[string]storedProfile) error {
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write profiles: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write profiles: %v", err)
+	}
+	return nil
+}
+
+func (p storedProfile) redacted() models.ConnectionProfile {
+	return models.ConnectionProfile{
+		Name:       p.Name,
+		Config:     p.Config,
+		HasSecrets: p.Secrets != "",
+		CreatedAt:  p.CreatedAt,
+		UpdatedAt:  p.UpdatedAt,
+	}
+}
+
+// LoadOrCreateKey returns the key stored at path, generating a random one
+// on first use. It is the fallback when no key is configured explicitly.
+func LoadOrCreateKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return string(data), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	key := base64.StdEncoding.EncodeToString(raw)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		return "", fmt.Errorf("failed to write key file: %v", err)
+	}
+	return key, nil
+}
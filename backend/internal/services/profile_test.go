@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clickhouse-integration/internal/models"
+)
+
+func newTestProfileStore(t *testing.T) *ProfileStore {
+	t.Helper()
+	store, err := NewProfileStore(filepath.Join(t.TempDir(), "profiles.json"), "test passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func createTestProfile(t *testing.T, store *ProfileStore) {
+	t.Helper()
+	_, err := store.Create(models.ConnectionProfile{Name: "warehouse", Config: models.ClickHouseConfig{
+		Host: "ch1.example", Port: 9000, User: "reader", Password: "old-secret",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedPassword(t *testing.T, store *ProfileStore) string {
+	t.Helper()
+	profile, err := store.Get("warehouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile.Config.Password
+}
+
+func TestProfilePasswordIsEncrypted(t *testing.T) {
+	store := newTestProfileStore(t)
+	createTestProfile(t, store)
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "old-secret") {
+		t.Error("password written in clear text")
+	}
+	redacted, err := store.GetRedacted("warehouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted.Config.Password != "" || !redacted.HasSecrets {
+		t.Errorf("redacted profile got %+v", redacted)
+	}
+}
+
+func TestProfileUpdateKeepsPasswordOnSameServer(t *testing.T) {
+	store := newTestProfileStore(t)
+	createTestProfile(t, store)
+
+	_, err := store.Update(models.ConnectionProfile{Name: "warehouse", Config: models.ClickHouseConfig{
+		Host: "ch1.example", Port: 9000, User: "reader", Database: "sales",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storedPassword(t, store); got != "old-secret" {
+		t.Errorf("password got %q, want the stored one kept", got)
+	}
+}
+
+func TestProfileUpdateRequiresPasswordForNewServer(t *testing.T) {
+	store := newTestProfileStore(t)
+	createTestProfile(t, store)
+
+	for _, config := range []models.ClickHouseConfig{
+		{Host: "ch2.example", Port: 9000, User: "reader"},
+		{Host: "ch1.example", Port: 9440, User: "reader"},
+	} {
+		_, err := store.Update(models.ConnectionProfile{Name: "warehouse", Config: config})
+		if !errors.Is(err, ErrProfilePasswordRequired) {
+			t.Errorf("%s:%d: got %v, want ErrProfilePasswordRequired", config.Host, config.Port, err)
+		}
+	}
+	if got := storedPassword(t, store); got != "old-secret" {
+		t.Errorf("rejected update changed the password to %q", got)
+	}
+
+	_, err := store.Update(models.ConnectionProfile{Name: "warehouse", Config: models.ClickHouseConfig{
+		Host: "ch2.example", Port: 9000, User: "reader", Password: "new-secret",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storedPassword(t, store); got != "new-secret" {
+		t.Errorf("password got %q, want new-secret", got)
+	}
+}
+
+func TestProfileUpdateClearsPassword(t *testing.T) {
+	store := newTestProfileStore(t)
+	createTestProfile(t, store)
+
+	// Clearing also allows moving to another server without a password
+	updated, err := store.Update(models.ConnectionProfile{Name: "warehouse", ClearSecrets: true, Config: models.ClickHouseConfig{
+		Host: "ch2.example", Port: 9000, User: "reader",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.HasSecrets {
+		t.Error("profile still reports a stored password")
+	}
+	if got := storedPassword(t, store); got != "" {
+		t.Errorf("password got %q, want it cleared", got)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"clickhouse-integration/internal/models"
+)
+
+var filterOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+
+	"like":     "LIKE",
+	"not_like": "NOT LIKE",
+}
+
+// buildExportQuery builds the SELECT for a structured export request.
+// Identifiers are quoted and every value is passed as a bound argument, so
+// nothing from the request is spliced into the SQL as text. wm is the
+// previous watermark of an incremental export, if any, and slice the part
+// of a parallel export to read.
+func buildExportQuery(req models.ExportRequest, wm *models.Watermark, slice *exportSlice) (string, []interface{}, error) {
+	var sb strings.Builder
+	var args []interface{}
+
+	sb.WriteString(buildSelectQuery(req.Table, req.Columns))
+
+	if req.Sample > 0 {
+		if req.Sample > 1 {
+			return "", nil, fmt.Errorf("sample must be a fraction between 0 and 1")
+		}
+		fmt.Fprintf(&sb, " SAMPLE %g", req.Sample)
+	}
+
+	var predicates []string
+	for _, filter := range req.Filters {
+		predicate, filterArgs, err := buildPredicate(filter)
+		if err != nil {
+			return "", nil, err
+		}
+		predicates = append(predicates, predicate)
+		args = append(args, filterArgs...)
+	}
+	if wm != nil {
+		// Cast to the recorded type so the stored text compares correctly
+		// against numbers, dates and strings alike
+		predicates = append(predicates, fmt.Sprintf("%s > CAST(?, ?)", quoteIdentifier(req.Incremental.Column)))
+		args = append(args, wm.Value, wm.Type)
+	}
+	if slice != nil && slice.predicate != "" {
+		predicates = append(predicates, slice.predicate)
+		args = append(args, slice.args...)
+	}
+	if len(predicates) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(predicates, " AND "))
+	}
+
+	var order []string
+	if req.Incremental != nil {
+		// Ascending watermark order makes the last row the new watermark
+		order = append(order, quoteIdentifier(req.Incremental.Column)+" ASC")
+	}
+	for _, o := range req.OrderBy {
+		if o.Column == "" {
+			return "", nil, fmt.Errorf("order by column is required")
+		}
+		direction := "ASC"
+		if o.Desc {
+			direction = "DESC"
+		}
+		order = append(order, quoteIdentifier(o.Column)+" "+direction)
+	}
+	if len(order) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(order, ", "))
+	}
+
+	if req.Limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %d", req.Limit)
+	}
+	if req.Offset > 0 {
+		fmt.Fprintf(&sb, " OFFSET %d", req.Offset)
+	}
+
+	return sb.String(), args, nil
+}
+
+func buildPredicate(filter models.ExportFilter) (string, []interface{}, error) {
+	if filter.Column == "" {
+		return "", nil, fmt.Errorf("filter column is required")
+	}
+	column := quoteIdentifier(filter.Column)
+
+	switch filter.Op {
+	case "is_null":
+		return column + " IS NULL", nil, nil
+	case "is_not_null":
+		return column + " IS NOT NULL", nil, nil
+	case "in", "not_in":
+		values := reflect.ValueOf(filter.Value)
+		if values.Kind() != reflect.Slice || values.Len() == 0 {
+			return "", nil, fmt.Errorf("filter %q on %s needs a non-empty list value", filter.Op, filter.Column)
+		}
+		// has() takes the bound array literal directly
+		predicate := fmt.Sprintf("has(?, %s)", column)
+		if filter.Op == "not_in" {
+			predicate = "NOT " + predicate
+		}
+		return predicate, []interface{}{filter.Value}, nil
+	}
+
+	op, ok := filterOperators[filter.Op]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported filter operator %q", filter.Op)
+	}
+	if filter.Value == nil {
+		return "", nil, fmt.Errorf("filter %q on %s needs a value", filter.Op, filter.Column)
+	}
+	return fmt.Sprintf("%s %s ?", column, op), []interface{}{filter.Value}, nil
+}
+
+func buildSelectQuery(table string, columns []string) string {
+	selectList := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, col := range columns {
+			quoted[i] = quoteIdentifier(col)
+		}
+		selectList = strings.Join(quoted, ", ")
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", selectList, quoteTableName(table))
+}
+
+// quoteIdentifier wraps a column or table name in backticks so names with
+// spaces, keywords or quotes cannot change the shape of the query.
+func quoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
+
+// quoteTableName quotes a table name that may be qualified as database.table.
+func quoteTableName(name string) string {
+	if database, table, found := strings.Cut(name, "."); found {
+		return quoteIdentifier(database) + "." + quoteIdentifier(table)
+	}
+	return quoteIdentifier(name)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var watermarkKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// watermarkKey identifies an incremental export across runs. A requested
+// key only names a watermark within the export's server, database and
+// table, so it cannot reach the watermarks of other tables or profiles.
+func watermarkKey(config models.ClickHouseConfig, req models.ExportRequest) string {
+	scope := fmt.Sprintf("%s:%d/%s/%s", config.Host, config.Port, config.Database, req.Table)
+	if config.Profile != "" {
+		scope = fmt.Sprintf("profile:%s/%s/%s", config.Profile, config.Database, req.Table)
+	}
+	if req.Incremental.Key != "" {
+		return scope + "#" + req.Incremental.Key
+	}
+	return scope + "/" + req.Incremental.Column
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"clickhouse-integration/internal/models"
+)
+
+func TestBuildExportQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   models.ExportRequest
+		wm    *models.Watermark
+		slice *exportSlice
+		query string
+		args  []interface{}
+	}{
+		{
+			name:  "all columns",
+			req:   models.ExportRequest{Table: "events"},
+			query: "SELECT * FROM `events`",
+		},
+		{
+			name:  "quoted identifiers",
+			req:   models.ExportRequest{Table: "db.my`table", Columns: []string{"a b", "c`) FROM x --"}},
+			query: "SELECT `a b`, `c\\`) FROM x --` FROM `db`.`my\\`table`",
+		},
+		{
+			name: "filters bind their values",
+			req: models.ExportRequest{Table: "t", Filters: []models.ExportFilter{
+				{Column: "name", Op: "=", Value: "x' OR 1=1"},
+				{Column: "id", Op: "in", Value: []interface{}{1, 2}},
+				{Column: "deleted", Op: "is_null"},
+			}},
+			query: "SELECT * FROM `t` WHERE `name` = ? AND has(?, `id`) AND `deleted` IS NULL",
+			args:  []interface{}{"x' OR 1=1", []interface{}{1, 2}},
+		},
+		{
+			name: "watermark predicate and order",
+			req: models.ExportRequest{
+				Table:       "t",
+				Filters:     []models.ExportFilter{{Column: "kind", Op: "=", Value: "a"}},
+				OrderBy:     []models.ExportOrder{{Column: "name", Desc: true}},
+				Incremental: &models.IncrementalExport{Column: "seq"},
+			},
+			wm:    &models.Watermark{Value: "41", Type: "UInt64"},
+			query: "SELECT * FROM `t` WHERE `kind` = ? AND `seq` > CAST(?, ?) ORDER BY `seq` ASC, `name` DESC",
+			args:  []interface{}{"a", "41", "UInt64"},
+		},
+		{
+			name:  "first incremental run",
+			req:   models.ExportRequest{Table: "t", Incremental: &models.IncrementalExport{Column: "seq"}},
+			query: "SELECT * FROM `t` ORDER BY `seq` ASC",
+		},
+		{
+			name:  "sample, limit and offset",
+			req:   models.ExportRequest{Table: "t", Sample: 0.1, Limit: 100, Offset: 200},
+			query: "SELECT * FROM `t` SAMPLE 0.1 LIMIT 100 OFFSET 200",
+		},
+		{
+			name:  "slice predicate",
+			req:   models.ExportRequest{Table: "t", Filters: []models.ExportFilter{{Column: "a", Op: ">", Value: 1}}},
+			slice: &exportSlice{predicate: "`k` >= ? AND `k` < ?", args: []interface{}{10, 20}},
+			query: "SELECT * FROM `t` WHERE `a` > ? AND `k` >= ? AND `k` < ?",
+			args:  []interface{}{1, 10, 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := buildExportQuery(tt.req, tt.wm, tt.slice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.query {
+				t.Errorf("query:\n got %s\nwant %s", query, tt.query)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args got %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBuildExportQueryRejectsInvalidRequests(t *testing.T) {
+	tests := map[string]models.ExportRequest{
+		"sample above 1":   {Table: "t", Sample: 2},
+		"unknown operator": {Table: "t", Filters: []models.ExportFilter{{Column: "a", Op: "like; DROP", Value: 1}}},
+		"missing value":    {Table: "t", Filters: []models.ExportFilter{{Column: "a", Op: "="}}},
+		"empty in list":    {Table: "t", Filters: []models.ExportFilter{{Column: "a", Op: "in", Value: []interface{}{}}}},
+		"unnamed order":    {Table: "t", OrderBy: []models.ExportOrder{{}}},
+		"unnamed filter":   {Table: "t", Filters: []models.ExportFilter{{Op: "is_null"}}},
+	}
+	for name, req := range tests {
+		if _, _, err := buildExportQuery(req, nil, nil); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestCheckWatermarkUnique(t *testing.T) {
+	result := func(values ...interface{}) *models.ExportResult {
+		r := &models.ExportResult{Columns: []models.Column{{Name: "seq", Type: "Nullable(UInt64)"}}}
+		for _, v := range values {
+			r.Rows = append(r.Rows, []interface{}{v})
+		}
+		return r
+	}
+	one, two := uint64(1), uint64(2)
+	var null *uint64
+
+	if err := checkWatermarkUnique("seq", result(&one, null, &two)); err != nil {
+		t.Errorf("increasing values: %v", err)
+	}
+	if err := checkWatermarkUnique("seq", result(null, null, &one)); err != nil {
+		t.Errorf("repeated NULLs: %v", err)
+	}
+	err := checkWatermarkUnique("seq", result(&one, &two, &two))
+	if err == nil || !strings.Contains(err.Error(), "not strictly increasing") {
+		t.Errorf("repeated value: got %v", err)
+	}
+	if err := checkWatermarkUnique("other", result(&one, &one)); err != nil {
+		t.Errorf("column not selected: %v", err)
+	}
+}
+
+func TestWatermarkKeyIsScopedToTable(t *testing.T) {
+	server := models.ClickHouseConfig{Host: "ch1", Port: 9000, Database: "sales"}
+	profile := models.ClickHouseConfig{Profile: "warehouse", Host: "ch1", Port: 9000, Database: "sales"}
+	req := func(table, key string) models.ExportRequest {
+		return models.ExportRequest{Table: table, Incremental: &models.IncrementalExport{Column: "seq", Key: key}}
+	}
+
+	tests := []struct {
+		config models.ClickHouseConfig
+		req    models.ExportRequest
+		want   string
+	}{
+		{server, req("orders", ""), "ch1:9000/sales/orders/seq"},
+		{profile, req("orders", ""), "profile:warehouse/sales/orders/seq"},
+		{server, req("orders", "nightly"), "ch1:9000/sales/orders#nightly"},
+		{profile, req("orders", "nightly"), "profile:warehouse/sales/orders#nightly"},
+	}
+	for _, tt := range tests {
+		if got := watermarkKey(tt.config, tt.req); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+
+	// The same requested key on another table or profile is another watermark
+	if watermarkKey(server, req("orders", "nightly")) == watermarkKey(server, req("refunds", "nightly")) {
+		t.Error("key is shared across tables")
+	}
+	if watermarkKey(profile, req("orders", "nightly")) == watermarkKey(server, req("orders", "nightly")) {
+		t.Error("key is shared between a profile and an inline connection")
+	}
+}
+
+func TestExportQueryRejectsInvalidIncrementalKey(t *testing.T) {
+	s := &ClickHouseService{}
+	for _, key := range []string{"../other", "profile:warehouse/sales/orders/seq", strings.Repeat("k", 65)} {
+		req := models.ExportRequest{Table: "t", Incremental: &models.IncrementalExport{Column: "seq", Key: key}}
+		if _, _, _, err := s.exportQuery(req); err == nil || !strings.Contains(err.Error(), "invalid incremental key") {
+			t.Errorf("%q: got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+)
+
+// OffsetMismatchError rejects a chunk that does not start at the committed
+// offset. Offset tells the client where to resume.
+type OffsetMismatchError struct {
+	Offset int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("chunk must start at offset %d", e.Offset)
+}
+
+// A resumable upload lives in UploadDir as <id>.part with its session in
+// <id>.session.json. Completing it turns the part file into a regular
+// upload, so everything that takes an upload ID works with it.
+
+// InitUpload starts a resumable upload owned by owner.
+func (s *FileService) InitUpload(req models.InitUploadRequest, owner string) (*models.UploadSession, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("file name is required")
+	}
+	if req.Size < 0 || req.Size > s.MaxResumableSize {
+		return nil, fmt.Errorf("%w of %d bytes", ErrUploadTooLarge, s.MaxResumableSize)
+	}
+	expected := strings.ToLower(req.SHA256)
+	if expected != "" && !isSHA256(expected) {
+		return nil, fmt.Errorf("sha256 must be 64 hex characters")
+	}
+
+	id, err := newFileID()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.UploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	part, err := os.OpenFile(s.uploadPartPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	part.Close()
+
+	now := time.Now().UTC()
+	session := &models.UploadSession{
+		ID:        id,
+		Name:      filepath.Base(req.Name),
+		Size:      req.Size,
+		SHA256:    expected,
+		Owner:     owner,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.writeSession(session); err != nil {
+		os.Remove(s.uploadPartPath(id))
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetUploadSession returns the state of a resumable upload, including the
+// offset to resume from.
+func (s *FileService) GetUploadSession(id, owner string) (*models.UploadSession, error) {
+	if !fileIDPattern.MatchString(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(s.uploadSessionPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload session: %v", err)
+	}
+
+	var session models.UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode upload session: %v", err)
+	}
+	if owner != "" && session.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+	return &session, nil
+}
+
+// AppendChunk writes chunk at offset, which must equal the committed
+// offset. The chunk is only committed when its SHA-256 matches checksum;
+// otherwise the part file is cut back so the client can resend it.
+func (s *FileService) AppendChunk(id, owner string, offset int64, checksum string, chunk io.Reader) (*models.UploadSession, error) {
+	if !isSHA256(strings.ToLower(checksum)) {
+		return nil, fmt.Errorf("chunk sha256 must be 64 hex characters")
+	}
+
+	unlock := s.lockSession(id)
+	defer unlock()
+
+	session, err := s.GetUploadSession(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return nil, &OffsetMismatchError{Offset: session.Offset}
+	}
+
+	part, err := os.OpenFile(s.uploadPartPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer part.Close()
+
+	// Bytes past the committed offset are left over from a chunk that was
+	// interrupted before its session update, so they are overwritten
+	if _, err := part.Seek(session.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %v", err)
+	}
+
+	limit := s.MaxChunkSize
+	if remaining := s.MaxResumableSize - session.Offset; remaining < limit {
+		limit = remaining
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(part, hash), io.LimitReader(chunk, limit+1))
+	switch {
+	case err != nil:
+		err = fmt.Errorf("failed to write chunk: %v", err)
+	case written > limit:
+		err = fmt.Errorf("%w: chunks are limited to %d bytes", ErrUploadTooLarge, limit)
+	case session.Size > 0 && session.Offset+written > session.Size:
+		err = fmt.Errorf("%w: chunk extends past the declared size of %d bytes", ErrUploadTooLarge, session.Size)
+	case hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(checksum):
+		err = fmt.Errorf("%w: chunk at offset %d", ErrChecksumMismatch, offset)
+	}
+	if err == nil {
+		err = part.Sync()
+	}
+	if err != nil {
+		part.Truncate(session.Offset)
+		return nil, err
+	}
+
+	session.Offset += written
+	session.UpdatedAt = time.Now().UTC()
+	if err := s.writeSession(session); err != nil {
+		part.Truncate(session.Offset - written)
+		return nil, err
+	}
+	return session, nil
+}
+
+// CompleteUpload verifies the whole file against the declared size and
+// checksum and turns it into a regular upload. checksum may override or
+// supply the SHA-256 given at init.
+func (s *FileService) CompleteUpload(id, owner, checksum string) (*models.Upload, error) {
+	unlock := s.lockSession(id)
+	defer unlock()
+
+	session, err := s.GetUploadSession(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if session.Size > 0 && session.Offset != session.Size {
+		return nil, fmt.Errorf("%w: %d of %d bytes received", ErrUploadIncomplete, session.Offset, session.Size)
+	}
+
+	partPath := s.uploadPartPath(id)
+	// Drop bytes of an interrupted chunk before hashing
+	if err := os.Truncate(partPath, session.Offset); err != nil {
+		return nil, fmt.Errorf("failed to finalize upload: %v", err)
+	}
+	sum, err := fileSHA256(partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := session.SHA256
+	if checksum != "" {
+		expected = strings.ToLower(checksum)
+	}
+	if expected != "" && sum != expected {
+		return nil, fmt.Errorf("%w: file sha256 is %s", ErrChecksumMismatch, sum)
+	}
+
+	upload := &models.Upload{
+		ID:        id,
+		Name:      session.Name,
+		Size:      session.Offset,
+		SHA256:    sum,
+		Owner:     session.Owner,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := os.Rename(partPath, s.uploadDataPath(id)); err != nil {
+		return nil, fmt.Errorf("failed to finalize upload: %v", err)
+	}
+	if err := s.writeUploadMeta(upload); err != nil {
+		return nil, err
+	}
+	os.Remove(s.uploadSessionPath(id))
+	s.sessionLocks.Delete(id)
+	return upload, nil
+}
+
+// AbortUpload discards a resumable upload.
+func (s *FileService) AbortUpload(id, owner string) error {
+	unlock := s.lockSession(id)
+	defer unlock()
+
+	if _, err := s.GetUploadSession(id, owner); err != nil {
+		return err
+	}
+	if err := os.Remove(s.uploadPartPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	if err := os.Remove(s.uploadSessionPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload session: %v", err)
+	}
+	s.sessionLocks.Delete(id)
+	return nil
+}
+
+func (s *FileService) lockSession(id string) func() {
+	value, _ := s.sessionLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (s *FileService) writeSession(session *models.UploadSession) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload session: %v", err)
+	}
+
+	path := s.uploadSessionPath(session.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload session: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write upload session: %v", err)
+	}
+	return nil
+}
+
+func (s *FileService) uploadPartPath(id string) string {
+	return filepath.Join(s.UploadDir, id+".part")
+}
+
+func (s *FileService) uploadSessionPath(id string) string {
+	return filepath.Join(s.UploadDir, id+".session.json")
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash upload file: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
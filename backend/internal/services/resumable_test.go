@@ -0,0 +1,192 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"clickhouse-integration/internal/models"
+)
+
+func newTestFileService(t *testing.T) *FileService {
+	t.Helper()
+	return &FileService{UploadDir: t.TempDir(), MaxResumableSize: 20, MaxChunkSize: 8}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func appendString(s *FileService, id string, offset int64, chunk string) (*models.UploadSession, error) {
+	return s.AppendChunk(id, "alice", offset, sha256Hex(chunk), strings.NewReader(chunk))
+}
+
+// partSize returns the size of the upload's part file.
+func partSize(t *testing.T, s *FileService, id string) int64 {
+	t.Helper()
+	info, err := os.Stat(s.uploadPartPath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestResumableUploadRoundTrip(t *testing.T) {
+	s := newTestFileService(t)
+	session, err := s.InitUpload(models.InitUploadRequest{Name: "../data.csv", Size: 12, SHA256: strings.ToUpper(sha256Hex("hello world!"))}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Name != "data.csv" {
+		t.Errorf("name got %q, want the base name", session.Name)
+	}
+
+	if session, err = appendString(s, session.ID, 0, "hello "); err != nil {
+		t.Fatal(err)
+	}
+	if session.Offset != 6 {
+		t.Errorf("offset got %d, want 6", session.Offset)
+	}
+
+	// A retried chunk that was already committed reports where to resume
+	var mismatch *OffsetMismatchError
+	if _, err := appendString(s, session.ID, 0, "hello "); !errors.As(err, &mismatch) || mismatch.Offset != 6 {
+		t.Fatalf("stale offset: got %v, want an offset mismatch at 6", err)
+	}
+
+	if _, err := appendString(s, session.ID, 6, "world!"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUploadSession(session.ID, "bob"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("other owner: got %v, want ErrUploadNotFound", err)
+	}
+
+	upload, err := s.CompleteUpload(session.ID, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Size != 12 || upload.SHA256 != sha256Hex("hello world!") || upload.Owner != "alice" {
+		t.Errorf("upload got %+v", upload)
+	}
+	data, err := os.ReadFile(s.uploadDataPath(session.ID))
+	if err != nil || string(data) != "hello world!" {
+		t.Errorf("data got %q, %v", data, err)
+	}
+	if _, err := os.Stat(s.uploadPartPath(session.ID)); !os.IsNotExist(err) {
+		t.Error("part file was left behind")
+	}
+	if _, err := s.GetUploadSession(session.ID, "alice"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("session after complete: got %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestAppendChunkTruncatesOnFailure(t *testing.T) {
+	s := newTestFileService(t)
+	session, err := s.InitUpload(models.InitUploadRequest{Name: "data.csv", Size: 10}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendString(s, session.ID, 0, "abcd"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		chunk string
+		sum   string
+		want  error
+	}{
+		{"checksum mismatch", "efgh", sha256Hex("other"), ErrChecksumMismatch},
+		{"larger than a chunk", "efghijklm", "", ErrUploadTooLarge},
+		{"past the declared size", "efghijk", "", ErrUploadTooLarge},
+	}
+	for _, tt := range tests {
+		sum := tt.sum
+		if sum == "" {
+			sum = sha256Hex(tt.chunk)
+		}
+		if _, err := s.AppendChunk(session.ID, "alice", 4, sum, strings.NewReader(tt.chunk)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if size := partSize(t, s, session.ID); size != 4 {
+			t.Errorf("%s: part file is %d bytes, want it cut back to 4", tt.name, size)
+		}
+		current, err := s.GetUploadSession(session.ID, "alice")
+		if err != nil || current.Offset != 4 {
+			t.Errorf("%s: session got %+v, %v", tt.name, current, err)
+		}
+	}
+
+	if _, err := appendString(s, session.ID, 4, "efgh"); err != nil {
+		t.Errorf("resent chunk: %v", err)
+	}
+}
+
+func TestResumableUploadSizeLimits(t *testing.T) {
+	s := newTestFileService(t)
+	if _, err := s.InitUpload(models.InitUploadRequest{Name: "big.csv", Size: 21}, "alice"); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("declared size over the limit: got %v", err)
+	}
+
+	// Without a declared size the total is still capped
+	session, err := s.InitUpload(models.InitUploadRequest{Name: "data.csv"}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := int64(0); offset < 16; offset += 8 {
+		if _, err := appendString(s, session.ID, offset, "12345678"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := appendString(s, session.ID, 16, "12345"); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("chunk past the total limit: got %v", err)
+	}
+	if _, err := appendString(s, session.ID, 16, "1234"); err != nil {
+		t.Errorf("chunk up to the total limit: %v", err)
+	}
+}
+
+func TestCompleteUploadVerifiesFile(t *testing.T) {
+	s := newTestFileService(t)
+
+	session, err := s.InitUpload(models.InitUploadRequest{Name: "data.csv", Size: 8}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendString(s, session.ID, 0, "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CompleteUpload(session.ID, "alice", ""); !errors.Is(err, ErrUploadIncomplete) {
+		t.Errorf("short upload: got %v, want ErrUploadIncomplete", err)
+	}
+
+	session, err = s.InitUpload(models.InitUploadRequest{Name: "data.csv", SHA256: sha256Hex("other")}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendString(s, session.ID, 0, "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	// Leftovers of an interrupted chunk past the committed offset
+	part, err := os.OpenFile(s.uploadPartPath(session.ID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.WriteString("junk")
+	part.Close()
+
+	if _, err := s.CompleteUpload(session.ID, "alice", ""); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("checksum from init: got %v, want ErrChecksumMismatch", err)
+	}
+	upload, err := s.CompleteUpload(session.ID, "alice", sha256Hex("abcd"))
+	if err != nil {
+		t.Fatalf("checksum given on complete: %v", err)
+	}
+	if upload.Size != 4 || upload.SHA256 != sha256Hex("abcd") {
+		t.Errorf("upload got %+v", upload)
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/config"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Client talks to an S3-compatible endpoint with requests signed by AWS
+// Signature Version 4. It covers only the calls this service needs.
+type s3Client struct {
+	cfg  config.S3Config
+	http *http.Client
+	now  func() time.Time
+}
+
+func newS3Client(cfg config.S3Config) *s3Client {
+	return &s3Client{cfg: cfg, http: http.DefaultClient, now: time.Now}
+}
+
+// GetObject streams an object's body. The caller closes it.
+func (c *s3Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, emptyPayloadHash)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %v", bucket, key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %s", bucket, key, s3ErrorMessage(resp))
+	}
+	return resp.Body, nil
+}
+
+// objectURL builds the URL of an object, path style or virtual-hosted.
+func (c *s3Client) objectURL(bucket, key string) (*url.URL, error) {
+	u, err := url.Parse(c.cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+
+	path := "/" + strings.TrimPrefix(key, "/")
+	if c.cfg.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	return u, nil
+}
+
+func (c *s3Client) newRequest(ctx context.Context, method, bucket, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("s3 bucket and key are required")
+	}
+	u, err := c.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil {
+		u.RawQuery = s3CanonicalQuery(query)
+	}
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// sign adds SigV4 headers to req. payloadHash is the hex SHA-256 of the
+// body.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.cfg.AccessKeyID == "" {
+		// Anonymous access to public buckets
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3EscapePath escapes each path segment as SigV4 requires, keeping the
+// slashes.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but the unreserved characters.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~", b) >= 0 {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func s3ErrorMessage(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var parsed struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(body, &parsed); err == nil && parsed.Code != "" {
+		return fmt.Sprintf("%s (%s: %s)", resp.Status, parsed.Code, parsed.Message)
+	}
+	return resp.Status
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3PartSize is the multipart chunk size. S3 needs at least 5 MiB for
+// every part but the last.
+const s3PartSize = 8 * 1024 * 1024
+
+// PutObject uploads body, reading it in parts so objects of any size are
+// streamed without being held in memory whole. Objects that fit in one
+// part are sent with a single PUT.
+func (c *s3Client) PutObject(ctx context.Context, bucket, key, contentType string, body io.Reader) error {
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(body, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.putSingle(ctx, bucket, key, contentType, part[:n])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read export data: %v", err)
+	}
+
+	uploadID, err := c.createMultipart(ctx, bucket, key, contentType)
+	if err != nil {
+		return err
+	}
+
+	var parts []s3CompletedPart
+	for number := 1; n > 0; number++ {
+		etag, err := c.uploadPart(ctx, bucket, key, uploadID, number, part[:n])
+		if err != nil {
+			c.abortMultipart(bucket, key, uploadID)
+			return err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+
+		n, err = io.ReadFull(body, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			c.abortMultipart(bucket, key, uploadID)
+			return fmt.Errorf("failed to read export data: %v", err)
+		}
+	}
+
+	if err := c.completeMultipart(ctx, bucket, key, uploadID, parts); err != nil {
+		c.abortMultipart(bucket, key, uploadID)
+		return err
+	}
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *s3Client) putSingle(ctx context.Context, bucket, key, contentType string, data []byte) error {
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, key, nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	_, err = c.do(req, hexSHA256(data), "put", bucket, key)
+	return err
+}
+
+func (c *s3Client) createMultipart(ctx context.Context, bucket, key, contentType string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.do(req, emptyPayloadHash, "start upload of", bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to start upload of s3://%s/%s: unexpected response", bucket, key)
+	}
+	return result.UploadID, nil
+}
+
+func (c *s3Client) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, key, query, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	c.sign(req, hexSHA256(data))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d of s3://%s/%s: %v", number, bucket, key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to upload part %d of s3://%s/%s: %s", number, bucket, key, s3ErrorMessage(resp))
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *s3Client) completeMultipart(ctx context.Context, bucket, key, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode upload completion: %v", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, hexSHA256(body), "complete upload of", bucket, key)
+	if err != nil {
+		return err
+	}
+	// S3 can report a failed completion with 200 and an Error document
+	if bytes.Contains(resp, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete upload of s3://%s/%s: %s", bucket, key, resp)
+	}
+	return nil
+}
+
+// abortMultipart releases the parts of a failed upload. It is best effort
+// and runs even when the request context is already cancelled.
+func (c *s3Client) abortMultipart(bucket, key, uploadID string) {
+	req, err := c.newRequest(context.Background(), http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return
+	}
+	c.do(req, emptyPayloadHash, "abort upload of", bucket, key)
+}
+
+// do signs and sends req and returns the response body of a 2xx reply.
+func (c *s3Client) do(req *http.Request, payloadHash, action, bucket, key string) ([]byte, error) {
+	c.sign(req, payloadHash)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s s3://%s/%s: %v", action, bucket, key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to %s s3://%s/%s: %s", action, bucket, key, s3ErrorMessage(resp))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s s3://%s/%s: %v", action, bucket, key, err)
+	}
+	return body, nil
+}
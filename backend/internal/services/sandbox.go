@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ErrQueryNotAllowed is returned for export queries that are not a single
+// read-only SELECT statement.
+var ErrQueryNotAllowed = errors.New("query not allowed")
+
+// QueryLimits are the server-side limits applied to every export query.
+// A zero value disables the corresponding limit.
+type QueryLimits struct {
+	MaxResultRows    uint64
+	MaxExecutionTime int
+	MaxMemoryUsage   uint64
+}
+
+// QueryLimitError reports that ClickHouse aborted a query because it hit
+// one of the sandbox limits.
+type QueryLimitError struct {
+	Limit string
+	Err   error
+}
+
+func (e *QueryLimitError) Error() string {
+	return fmt.Sprintf("query exceeded the %s limit: %v", e.Limit, e.Err)
+}
+
+func (e *QueryLimitError) Unwrap() error {
+	return e.Err
+}
+
+// readOnlyContext attaches readonly=1 and the configured limits to ctx.
+// Under readonly=1 the server also refuses SETTINGS clauses in the query,
+// so callers cannot raise the limits themselves.
+func (l QueryLimits) readOnlyContext(ctx context.Context) context.Context {
+	settings := clickhouse.Settings{
+		"readonly":             1,
+		"result_overflow_mode": "throw",
+	}
+	if l.MaxResultRows > 0 {
+		settings["max_result_rows"] = l.MaxResultRows
+	}
+	if l.MaxExecutionTime > 0 {
+		settings["max_execution_time"] = l.MaxExecutionTime
+	}
+	if l.MaxMemoryUsage > 0 {
+		settings["max_memory_usage"] = l.MaxMemoryUsage
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+}
+
+// wrapLimitError turns the ClickHouse exceptions raised by the sandbox
+// settings into errors that say which limit was hit.
+func (l QueryLimits) wrapLimitError(err error) error {
+	var exception *clickhouse.Exception
+	if !errors.As(err, &exception) {
+		return err
+	}
+
+	switch exception.Code {
+	case 396: // TOO_MANY_ROWS_OR_BYTES
+		return &QueryLimitError{Limit: fmt.Sprintf("%d result rows", l.MaxResultRows), Err: err}
+	case 159: // TIMEOUT_EXCEEDED
+		return &QueryLimitError{Limit: fmt.Sprintf("%ds execution time", l.MaxExecutionTime), Err: err}
+	case 241: // MEMORY_LIMIT_EXCEEDED
+		return &QueryLimitError{Limit: fmt.Sprintf("%d bytes memory", l.MaxMemoryUsage), Err: err}
+	case 164: // READONLY
+		return fmt.Errorf("%w: query tried to modify data or settings: %v", ErrQueryNotAllowed, err)
+	}
+	return err
+}
+
+// ValidateReadOnlyQuery accepts a single SELECT (optionally starting with a
+// WITH clause) and rejects everything else before it reaches the server.
+func ValidateReadOnlyQuery(query string) error {
+	words, err := queryWords(query)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("%w: query is empty", ErrQueryNotAllowed)
+	}
+
+	if first := words[0]; first != "SELECT" && first != "WITH" {
+		return fmt.Errorf("%w: only SELECT statements are allowed, got %s", ErrQueryNotAllowed, first)
+	}
+	for i := 0; i+1 < len(words); i++ {
+		if words[i] == "INTO" && words[i+1] == "OUTFILE" {
+			return fmt.Errorf("%w: INTO OUTFILE is not allowed", ErrQueryNotAllowed)
+		}
+	}
+
+	return nil
+}
+
+// queryWords returns the upper-cased keywords and identifiers of a query,
+// skipping comments, string literals and quoted identifiers. It fails if
+// the query contains more than one statement.
+func queryWords(query string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	terminated := false
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-', r == '#':
+			flush()
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			flush()
+			j := i + 2
+			for ; j+1 < len(runes) && !(runes[j] == '*' && runes[j+1] == '/'); j++ {
+			}
+			if j+1 >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrQueryNotAllowed)
+			}
+			i = j + 1
+		case r == '\'' || r == '"' || r == '`':
+			flush()
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated quoted string", ErrQueryNotAllowed)
+			}
+			i = j
+		case r == ';':
+			flush()
+			terminated = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if terminated {
+				return nil, fmt.Errorf("%w: multiple statements are not allowed", ErrQueryNotAllowed)
+			}
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return words, nil
+}
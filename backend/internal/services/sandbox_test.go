@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{"select", "SELECT * FROM t", true},
+		{"lower case", "select 1", true},
+		{"trailing semicolon", "SELECT 1;\n", true},
+		{"with clause", "WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"leading comment", "-- note\nSELECT 1", true},
+		{"leading block comment", "/* INSERT */ SELECT 1", true},
+		{"hash comment", "# DROP TABLE t\nSELECT 1", true},
+		{"semicolon in string", "SELECT ';DROP TABLE t' FROM t", true},
+		{"outfile in string", "SELECT 'INTO OUTFILE' FROM t", true},
+		{"escaped quote in string", `SELECT 'it\'s; INTO OUTFILE' FROM t`, true},
+		{"backtick identifier", "SELECT `into outfile`, `a;b` FROM `my table`", true},
+		{"comment after semicolon", "SELECT 1; -- done", true},
+
+		{"empty", "", false},
+		{"only comment", "-- SELECT 1", false},
+		{"insert", "INSERT INTO t VALUES (1)", false},
+		{"drop", "DROP TABLE t", false},
+		{"alter", "ALTER TABLE t DELETE WHERE 1", false},
+		{"set", "SET readonly = 0", false},
+		{"verb after comment", "/* SELECT */ DELETE FROM t WHERE 1", false},
+		{"into outfile", "SELECT * FROM t INTO OUTFILE '/tmp/x'", false},
+		{"into outfile across comment", "SELECT * FROM t INTO /* x */ outfile '/tmp/x'", false},
+		{"two statements", "SELECT 1; DROP TABLE t", false},
+		{"two selects", "SELECT 1;SELECT 2", false},
+		{"statement after comment", "SELECT 1; /* x */ SELECT 2", false},
+		{"unterminated string", "SELECT 'abc", false},
+		{"unterminated identifier", "SELECT `abc", false},
+		{"unterminated comment", "SELECT 1 /* x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReadOnlyQuery(tt.query)
+			if tt.allowed && err != nil {
+				t.Errorf("%q: %v", tt.query, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrQueryNotAllowed) {
+				t.Errorf("%q: got %v, want ErrQueryNotAllowed", tt.query, err)
+			}
+		})
+	}
+}
+
+func TestQueryWordsSkipsQuotedText(t *testing.T) {
+	words, err := queryWords("select `a b`, \"c\", 'd' from t -- e\nwhere x_1 = 2 /* f */")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SELECT", "FROM", "T", "WHERE", "X_1", "2"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("got %v, want %v", words, want)
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"clickhouse-integration/internal/config"
+	"clickhouse-integration/internal/models"
+)
+
+var (
+	ErrSourceNotAllowed = errors.New("import source not allowed")
+	ErrSourceNotFound   = errors.New("import source not found")
+)
+
+// SourceService opens the files an import reads from: uploads, files in
+// configured server directories, HTTP(S) URLs and S3 objects. Every source
+// is streamed, never copied to disk first. Its S3 endpoints are also where
+// exports with s3 output are written.
+type SourceService struct {
+	files *FileService
+	cfg   config.SourcesConfig
+	http  *http.Client
+}
+
+func NewSourceService(cfg config.SourcesConfig, files *FileService) *SourceService {
+	s := &SourceService{files: files, cfg: cfg}
+	s.http = &http.Client{
+		// A redirect must not lead an allowed host to a disallowed one
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("too many redirects")
+			}
+			return s.checkURL(req.URL)
+		},
+	}
+	return s
+}
+
+// Open returns a reader over the source and a name for messages. owner
+// scopes upload lookups as in FileService.GetUpload.
+func (s *SourceService) Open(ctx context.Context, src models.ImportSource, owner string) (io.ReadCloser, string, error) {
+	switch src.Type {
+	case "", models.SourceUpload:
+		path, err := s.files.UploadPath(src.UploadID, owner)
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open upload: %v", err)
+		}
+		return file, "upload " + src.UploadID, nil
+
+	case models.SourceServer:
+		path, err := s.ServerPath(src.Dir, src.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open %s:%s: %v", src.Dir, src.Path, err)
+		}
+		return file, src.Dir + ":" + src.Path, nil
+
+	case models.SourceURL:
+		body, err := s.openURL(ctx, src.URL)
+		return body, src.URL, err
+
+	case models.SourceS3:
+		client, err := s.S3Client(src.Endpoint, src.Bucket)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := client.GetObject(ctx, src.Bucket, src.Key)
+		return body, fmt.Sprintf("s3://%s/%s", src.Bucket, src.Key), err
+	}
+	return nil, "", fmt.Errorf("unknown import source type %q", src.Type)
+}
+
+// ServerPath resolves path inside the named server directory. Symlinks are
+// resolved first so a link cannot lead outside the directory.
+func (s *SourceService) ServerPath(dir, path string) (string, error) {
+	root, ok := s.cfg.ServerDirs[dir]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown server directory %q", ErrSourceNotAllowed, dir)
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("server directory %q is unavailable: %v", dir, err)
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Clean("/"+path)))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s:%s", ErrSourceNotFound, dir, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s:%s: %v", dir, path, err)
+	}
+	if !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s:%s is outside the directory", ErrSourceNotAllowed, dir, path)
+	}
+	return resolved, nil
+}
+
+// S3Client returns the client for a configured S3 endpoint, checking the
+// bucket against the endpoint's allowlist.
+func (s *SourceService) S3Client(endpoint, bucket string) (*s3Client, error) {
+	cfg, ok := s.cfg.S3[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown s3 endpoint %q", ErrSourceNotAllowed, endpoint)
+	}
+	if len(cfg.Buckets) > 0 && !containsString(cfg.Buckets, bucket) {
+		return nil, fmt.Errorf("%w: bucket %q is not allowed on s3 endpoint %q", ErrSourceNotAllowed, bucket, endpoint)
+	}
+	return newS3Client(cfg), nil
+}
+
+func (s *SourceService) openURL(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	if err := s.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", rawURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, rawURL)
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// checkURL allows http and https URLs to the configured hosts only, so
+// imports cannot be used to reach arbitrary internal services.
+func (s *SourceService) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: url scheme must be http or https", ErrSourceNotAllowed)
+	}
+	host := u.Hostname()
+	for _, allowed := range s.cfg.URLHosts {
+		if allowed == "*" || strings.EqualFold(allowed, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host %q is not allowed", ErrSourceNotAllowed, host)
+}
+
+// TableFunction returns a ClickHouse table function reading src, with its
+// arguments bound separately. ClickHouse fetches the data itself, so the
+// same host allowlist applies as for imports through this server, and S3
+// credentials are taken from the configured endpoint.
+func (s *SourceService) TableFunction(src models.ImportSource, format, structure string) (string, []interface{}, error) {
+	switch src.Type {
+	case models.SourceURL:
+		u, err := url.Parse(src.URL)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid url: %v", err)
+		}
+		if err := s.checkURL(u); err != nil {
+			return "", nil, err
+		}
+		return "url(?, ?, ?)", []interface{}{u.String(), format, structure}, nil
+
+	case models.SourceS3:
+		client, err := s.S3Client(src.Endpoint, src.Bucket)
+		if err != nil {
+			return "", nil, err
+		}
+		u, err := client.objectURL(src.Bucket, src.Key)
+		if err != nil {
+			return "", nil, err
+		}
+		if client.cfg.AccessKeyID == "" {
+			return "s3(?, ?, ?)", []interface{}{u.String(), format, structure}, nil
+		}
+		return "s3(?, ?, ?, ?, ?)", []interface{}{u.String(), client.cfg.AccessKeyID, client.cfg.SecretAccessKey, format, structure}, nil
+
+	case models.SourceClickHouseFile:
+		// ClickHouse confines file() to its user_files directory; relative
+		// paths without ".." keep the request from probing around it
+		if src.Path == "" || filepath.IsAbs(src.Path) || strings.Contains(src.Path, "..") {
+			return "", nil, fmt.Errorf("%w: file path must be relative to user_files", ErrSourceNotAllowed)
+		}
+		return "file(?, ?, ?)", []interface{}{src.Path, format, structure}, nil
+	}
+	return "", nil, fmt.Errorf("source type %q cannot be read by ClickHouse directly", src.Type)
+}
+
+// ImportRowReader reads a delimited file with a header line row by row and
+// converts each value according to the import column at the same index.
+// Values that do not parse are passed on as strings.
+type ImportRowReader struct {
+	reader  *csv.Reader
+	columns []models.Column
+	rows    int
+}
+
+// NewImportRowReader consumes the header line of r.
+func NewImportRowReader(r io.Reader, delimiter rune, columns []models.Column) (*ImportRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+
+	// Skip header
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("failed to read file header: %v", err)
+	}
+	return &ImportRowReader{reader: reader, columns: columns}, nil
+}
+
+// Next returns the next row, or io.EOF after the last one.
+func (r *ImportRowReader) Next() ([]interface{}, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read row %d: %v", r.rows+1, err)
+	}
+	r.rows++
+
+	values := make([]interface{}, len(row))
+	for i, val := range row {
+		values[i] = val
+		if i < len(r.columns) {
+			values[i] = convertImportValue(val, r.columns[i].Type)
+		}
+	}
+	return values, nil
+}
+
+func convertImportValue(val, chType string) interface{} {
+	switch ParseType(chType).Name {
+	case "Int32", "Int64", "UInt32", "UInt64":
+		if num, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return num
+		}
+	case "Float32", "Float64":
+		if num, err := strconv.ParseFloat(val, 64); err == nil {
+			return num
+		}
+	case "Date", "DateTime":
+		if t, err := time.Parse("2006-01-02", val); err == nil {
+			return t
+		}
+	}
+	return val
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"clickhouse-integration/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	DefaultTopN = 5
+	MaxTopN     = 100
+)
+
+// GetTableStats reads table metadata and part sizes from the system tables
+// and profiles every column. The null counts, distinct estimates and
+// min/max of all columns come from a single scan; top values take one
+// GROUP BY per column. A failing query leaves its error in the affected
+// profiles instead of failing the whole request.
+func (s *ClickHouseService) GetTableStats(conn driver.Conn, database, table string, topN int) (*models.TableStats, error) {
+	database, table = splitTableName(database, table)
+	topN = clampTopN(topN)
+
+	stats := &models.TableStats{Database: database, Table: table}
+
+	ctx := s.Limits.readOnlyContext(context.Background())
+	rows, err := conn.Query(ctx, `
+		SELECT engine, partition_key, sorting_key, primary_key
+		FROM system.tables
+		WHERE database = ? AND name = ?
+	`, database, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table metadata: %v", err)
+	}
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&stats.Engine, &stats.PartitionKey, &stats.SortingKey, &stats.PrimaryKey)
+	}
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table metadata: %v", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("table %s.%s not found", database, table)
+	}
+
+	if err := conn.QueryRow(ctx, `
+		SELECT count(), sum(rows), sum(data_compressed_bytes), sum(data_uncompressed_bytes)
+		FROM system.parts
+		WHERE active AND database = ? AND table = ?
+	`, database, table).Scan(&stats.Parts, &stats.Rows, &stats.CompressedBytes, &stats.UncompressedBytes); err != nil {
+		return nil, fmt.Errorf("failed to query table parts: %v", err)
+	}
+
+	columns, err := s.GetColumns(conn, database, table)
+	if err != nil {
+		return nil, err
+	}
+
+	qualified := quoteIdentifier(database) + "." + quoteIdentifier(table)
+	stats.Columns = make([]models.ColumnProfile, len(columns))
+	for i, col := range columns {
+		stats.Columns[i] = models.ColumnProfile{Name: col.Name, Type: col.Type}
+	}
+	if err := s.profileColumns(ctx, conn, qualified, stats.Columns); err != nil {
+		for i := range stats.Columns {
+			stats.Columns[i].Error = err.Error()
+		}
+	}
+	for i := range stats.Columns {
+		profile := &stats.Columns[i]
+		if err := s.topValues(ctx, conn, qualified, profile, topN); err != nil && profile.Error == "" {
+			profile.Error = err.Error()
+		}
+	}
+
+	return stats, nil
+}
+
+// profileColumns fills the null count, distinct estimate and min/max of
+// every profile in one query over the table.
+func (s *ClickHouseService) profileColumns(ctx context.Context, conn driver.Conn, table string, profiles []models.ColumnProfile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	query, targets := profileColumnsQuery(table, profiles)
+	if err := conn.QueryRow(ctx, query).Scan(targets...); err != nil {
+		return fmt.Errorf("failed to profile columns: %v", s.Limits.wrapLimitError(err))
+	}
+	return nil
+}
+
+// profileColumnsQuery returns the aggregate query for profileColumns and
+// the scan targets in the profiles, four per column.
+func profileColumnsQuery(table string, profiles []models.ColumnProfile) (string, []interface{}) {
+	aggregates := make([]string, len(profiles))
+	targets := make([]interface{}, 0, 4*len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		column := quoteIdentifier(profile.Name)
+
+		// Map, JSON and aggregate states have no ordering, so min/max are skipped
+		minMax := fmt.Sprintf("ifNull(toString(min(%[1]s)), ''), ifNull(toString(max(%[1]s)), '')", column)
+		if !isComparableType(profile.Type) {
+			minMax = "'', ''"
+		}
+		aggregates[i] = fmt.Sprintf("countIf(isNull(%[1]s)), uniq(%[1]s), %[2]s", column, minMax)
+		targets = append(targets, &profile.NullCount, &profile.DistinctEstimate, &profile.Min, &profile.Max)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(aggregates, ", "), table), targets
+}
+
+// topValues fills a profile's most frequent values.
+func (s *ClickHouseService) topValues(ctx context.Context, conn driver.Conn, table string, profile *models.ColumnProfile, topN int) error {
+	column := quoteIdentifier(profile.Name)
+	query := fmt.Sprintf(`
+		SELECT ifNull(toString(%[1]s), 'NULL') AS value, count() AS cnt
+		FROM %[2]s
+		GROUP BY value
+		ORDER BY cnt DESC
+		LIMIT %[3]d
+	`, column, table, topN)
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query top values: %v", s.Limits.wrapLimitError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vc models.ValueCount
+		if err := rows.Scan(&vc.Value, &vc.Count); err != nil {
+			return fmt.Errorf("failed to scan top value: %v", err)
+		}
+		profile.TopValues = append(profile.TopValues, vc)
+	}
+	return rows.Err()
+}
+
+func isComparableType(chType string) bool {
+	base := baseType(chType)
+	for _, prefix := range []string{"Map(", "Object(", "JSON", "AggregateFunction(", "SimpleAggregateFunction("} {
+		if strings.HasPrefix(base, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func clampTopN(topN int) int {
+	if topN <= 0 {
+		return DefaultTopN
+	}
+	if topN > MaxTopN {
+		return MaxTopN
+	}
+	return topN
+}
+
+// maxTrackedValues caps the distinct values counted per file column. Past
+// the cap, new values are no longer tracked, so DistinctEstimate becomes a
+// lower bound and TopValues an approximation.
+const maxTrackedValues = 100000
+
+type fileColumnProfile struct {
+	nulls      uint64
+	counts     map[string]uint64
+	numeric    bool
+	numMin     float64
+	numMax     float64
+	strMin     string
+	strMax     string
+	seenValues bool
+}
+
+// ProfileFile streams a delimited file and profiles each column of the
+// header. Empty cells count as nulls; min and max compare numerically when
+// every non-empty value in the column is a number.
+func (s *FileService) ProfileFile(filePath string, delimiter rune, topN int) (*models.FileProfile, error) {
+	topN = clampTopN(topN)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file header: %v", err)
+	}
+
+	columns := make([]*fileColumnProfile, len(header))
+	for i := range columns {
+		columns[i] = &fileColumnProfile{counts: make(map[string]uint64), numeric: true}
+	}
+
+	profile := &models.FileProfile{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row %d: %v", profile.Rows+1, err)
+		}
+		profile.Rows++
+
+		for i, col := range columns {
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+			col.add(value)
+		}
+	}
+
+	for i, col := range columns {
+		profile.Columns = append(profile.Columns, col.result(header[i], topN))
+	}
+	return profile, nil
+}
+
+func (p *fileColumnProfile) add(value string) {
+	if value == "" {
+		p.nulls++
+		return
+	}
+
+	if _, ok := p.counts[value]; ok || len(p.counts) < maxTrackedValues {
+		p.counts[value]++
+	}
+
+	if !p.seenValues {
+		p.strMin, p.strMax = value, value
+	} else if value < p.strMin {
+		p.strMin = value
+	} else if value > p.strMax {
+		p.strMax = value
+	}
+
+	if p.numeric {
+		n, err := strconv.ParseFloat(value, 64)
+		switch {
+		case err != nil:
+			p.numeric = false
+		case !p.seenValues:
+			p.numMin, p.numMax = n, n
+		default:
+			p.numMin, p.numMax = math.Min(p.numMin, n), math.Max(p.numMax, n)
+		}
+	}
+
+	p.seenValues = true
+}
+
+func (p *fileColumnProfile) result(name string, topN int) models.ColumnProfile {
+	profile := models.ColumnProfile{
+		Name:             name,
+		Type:             "String",
+		NullCount:        p.nulls,
+		DistinctEstimate: uint64(len(p.counts)),
+	}
+	if !p.seenValues {
+		return profile
+	}
+
+	profile.Min, profile.Max = p.strMin, p.strMax
+	if p.numeric {
+		profile.Type = "Float64"
+		profile.Min = strconv.FormatFloat(p.numMin, 'g', -1, 64)
+		profile.Max = strconv.FormatFloat(p.numMax, 'g', -1, 64)
+	}
+
+	for value, count := range p.counts {
+		profile.TopValues = append(profile.TopValues, models.ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(profile.TopValues, func(i, j int) bool {
+		a, b := profile.TopValues[i], profile.TopValues[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Value < b.Value
+	})
+	if len(profile.TopValues) > topN {
+		profile.TopValues = profile.TopValues[:topN]
+	}
+
+	return profile
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"clickhouse-integration/internal/models"
+)
+
+func TestProfileColumnsQueryScansOnce(t *testing.T) {
+	profiles := []models.ColumnProfile{
+		{Name: "id", Type: "UInt64"},
+		{Name: "attrs", Type: "Map(String, String)"},
+	}
+
+	query, targets := profileColumnsQuery("`db`.`t`", profiles)
+
+	want := "SELECT countIf(isNull(`id`)), uniq(`id`), ifNull(toString(min(`id`)), ''), ifNull(toString(max(`id`)), ''), " +
+		"countIf(isNull(`attrs`)), uniq(`attrs`), '', '' FROM `db`.`t`"
+	if query != want {
+		t.Errorf("query:\n got %s\nwant %s", query, want)
+	}
+	if len(targets) != 8 {
+		t.Fatalf("got %d scan targets, want 8", len(targets))
+	}
+	if targets[4] != &profiles[1].NullCount || targets[7] != &profiles[1].Max {
+		t.Error("scan targets do not point into the second profile")
+	}
+}
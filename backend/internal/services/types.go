@@ -0,0 +1,166 @@
+package services
+
+import (
+	"strings"
+
+	"clickhouse-integration/internal/models"
+)
+
+// ParseType breaks a ClickHouse type name into its structure, e.g.
+// "LowCardinality(Nullable(String))" or "Map(String, Array(UInt64))".
+func ParseType(chType string) models.TypeInfo {
+	var info models.TypeInfo
+
+	chType = strings.TrimSpace(chType)
+	for {
+		if inner, ok := unwrapType(chType, "Nullable"); ok {
+			info.Nullable = true
+			chType = inner
+		} else if inner, ok := unwrapType(chType, "LowCardinality"); ok {
+			info.LowCardinality = true
+			chType = inner
+		} else {
+			break
+		}
+	}
+
+	info.Name = chType
+	if open := strings.IndexByte(chType, '('); open >= 0 {
+		info.Name = strings.TrimSpace(chType[:open])
+	}
+
+	args := typeArgs(chType)
+	switch info.Name {
+	case "Array":
+		element := ParseType(typeAt(args, 0))
+		info.Element = &element
+	case "Map":
+		key, value := ParseType(typeAt(args, 0)), ParseType(typeAt(args, 1))
+		info.Key, info.Value = &key, &value
+	case "Tuple", "Nested":
+		for _, arg := range args {
+			info.Fields = append(info.Fields, parseTypeField(arg))
+		}
+	default:
+		info.Params = args
+	}
+
+	return info
+}
+
+// parseTypeField parses a Tuple element, which is either a bare type or a
+// "name Type" pair.
+func parseTypeField(arg string) models.TypeField {
+	name, typ := splitTypeField(arg)
+	return models.TypeField{Name: name, Type: ParseType(typ)}
+}
+
+// splitTypeField splits a Tuple element into its name, empty for a bare
+// type, and its type. A name never contains "(", while the text before the
+// first space of a parameterised type like "Decimal(10, 2)" always does.
+// Names that need it are quoted with backticks and may contain anything.
+func splitTypeField(arg string) (string, string) {
+	if strings.HasPrefix(arg, "`") {
+		for i := 1; i < len(arg); i++ {
+			switch arg[i] {
+			case '\\':
+				i++
+			case '`':
+				name := strings.NewReplacer("\\`", "`", "\\\\", "\\").Replace(arg[1:i])
+				return name, strings.TrimSpace(arg[i+1:])
+			}
+		}
+	}
+	if name, typ, found := strings.Cut(arg, " "); found && !strings.Contains(name, "(") {
+		return name, strings.TrimSpace(typ)
+	}
+	return "", arg
+}
+
+// canBeNullable reports whether ClickHouse allows Nullable(T) for the type.
+func canBeNullable(info models.TypeInfo) bool {
+	switch info.Name {
+	case "Array", "Map", "Tuple", "Nested", "LowCardinality":
+		return false
+	}
+	return true
+}
+
+func unwrapType(chType, wrapper string) (string, bool) {
+	if strings.HasPrefix(chType, wrapper+"(") && strings.HasSuffix(chType, ")") {
+		return strings.TrimSpace(chType[len(wrapper)+1 : len(chType)-1]), true
+	}
+	return "", false
+}
+
+// baseType strips the Nullable and LowCardinality wrappers, which do not
+// change how a value is rendered.
+func baseType(chType string) string {
+	chType = strings.TrimSpace(chType)
+	for _, wrapper := range []string{"Nullable(", "LowCardinality("} {
+		if strings.HasPrefix(chType, wrapper) && strings.HasSuffix(chType, ")") {
+			return baseType(chType[len(wrapper) : len(chType)-1])
+		}
+	}
+	return chType
+}
+
+func elementType(chType string) string {
+	if strings.HasPrefix(chType, "Array(") {
+		return typeAt(typeArgs(chType), 0)
+	}
+	return ""
+}
+
+// typeArgs splits the top-level arguments of a parameterised type, so
+// "Map(String, Array(Tuple(Int8, String)))" yields
+// ["String", "Array(Tuple(Int8, String))"].
+func typeArgs(chType string) []string {
+	open := strings.IndexByte(chType, '(')
+	if open < 0 || !strings.HasSuffix(chType, ")") {
+		return nil
+	}
+	inner := chType[open+1 : len(chType)-1]
+
+	var args []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(inner[start:]))
+}
+
+func typeAt(types []string, i int) string {
+	if i < len(types) {
+		return types[i]
+	}
+	return ""
+}
+
+// isNumericType reports whether a parsed type name is an integer, float or
+// decimal.
+func isNumericType(name string) bool {
+	for _, prefix := range []string{"Int", "UInt", "Float", "Decimal"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"clickhouse-integration/internal/models"
+)
+
+func TestParseType(t *testing.T) {
+	str := models.TypeInfo{Name: "String"}
+	tests := []struct {
+		chType string
+		want   models.TypeInfo
+	}{
+		{"UInt64", models.TypeInfo{Name: "UInt64"}},
+		{" Nullable(Int32) ", models.TypeInfo{Name: "Int32", Nullable: true}},
+		{"LowCardinality(Nullable(String))", models.TypeInfo{Name: "String", Nullable: true, LowCardinality: true}},
+		{"Decimal(18, 4)", models.TypeInfo{Name: "Decimal", Params: []string{"18", "4"}}},
+		{"DateTime64(3, 'UTC')", models.TypeInfo{Name: "DateTime64", Params: []string{"3", "'UTC'"}}},
+		{"DateTime('Europe/Paris, x')", models.TypeInfo{Name: "DateTime", Params: []string{"'Europe/Paris, x'"}}},
+		{"Array(Nullable(Float64))", models.TypeInfo{Name: "Array",
+			Element: &models.TypeInfo{Name: "Float64", Nullable: true}}},
+		{"Map(String, Tuple(a Int8, b String))", models.TypeInfo{Name: "Map", Key: &str,
+			Value: &models.TypeInfo{Name: "Tuple", Fields: []models.TypeField{
+				{Name: "a", Type: models.TypeInfo{Name: "Int8"}},
+				{Name: "b", Type: str},
+			}}}},
+		{"Tuple(Decimal(10, 2), `x y` String)", models.TypeInfo{Name: "Tuple", Fields: []models.TypeField{
+			{Type: models.TypeInfo{Name: "Decimal", Params: []string{"10", "2"}}},
+			{Name: "x y", Type: str},
+		}}},
+		{"Tuple(`a, (b)` Int8, `c\\`d` String)", models.TypeInfo{Name: "Tuple", Fields: []models.TypeField{
+			{Name: "a, (b)", Type: models.TypeInfo{Name: "Int8"}},
+			{Name: "c`d", Type: str},
+		}}},
+		{"Map(String, Array(Tuple(Int8, String)))", models.TypeInfo{Name: "Map", Key: &str,
+			Value: &models.TypeInfo{Name: "Array", Element: &models.TypeInfo{Name: "Tuple", Fields: []models.TypeField{
+				{Type: models.TypeInfo{Name: "Int8"}},
+				{Type: str},
+			}}}}},
+
+		// Malformed types parse without panicking and yield no arguments
+		{"", models.TypeInfo{}},
+		{"Decimal(18, 4", models.TypeInfo{Name: "Decimal"}},
+		{"Array(UInt8", models.TypeInfo{Name: "Array", Element: &models.TypeInfo{}}},
+		{"Nullable(", models.TypeInfo{Name: "Nullable"}},
+		{"Map(String)", models.TypeInfo{Name: "Map", Key: &str, Value: &models.TypeInfo{}}},
+	}
+	for _, tt := range tests {
+		if got := ParseType(tt.chType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseType(%q):\n got %+v\nwant %+v", tt.chType, got, tt.want)
+		}
+	}
+}
+
+func TestTypeArgs(t *testing.T) {
+	tests := []struct {
+		chType string
+		want   []string
+	}{
+		{"String", nil},
+		{"FixedString(16)", []string{"16"}},
+		{"Map(String, Array(Tuple(Int8, String)))", []string{"String", "Array(Tuple(Int8, String))"}},
+		{`Enum8('a,b' = 1, 'it\'s (x)' = 2)`, []string{"'a,b' = 1", `'it\'s (x)' = 2`}},
+		{"Decimal(18, 4", nil},
+	}
+	for _, tt := range tests {
+		if got := typeArgs(tt.chType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("typeArgs(%q) got %q, want %q", tt.chType, got, tt.want)
+		}
+	}
+}
+
+func TestCanBeNullable(t *testing.T) {
+	tests := map[string]bool{
+		"String":                 true,
+		"Decimal(18, 4)":         true,
+		"DateTime64(3, 'UTC')":   true,
+		"Nullable(Int8)":         true,
+		"Array(Nullable(Int8))":  false,
+		"Map(String, UInt64)":    false,
+		"Tuple(a Int8)":          false,
+		"Nested(a Int8)":         false,
+		"LowCardinality(String)": true,
+	}
+	for chType, want := range tests {
+		if got := canBeNullable(ParseType(chType)); got != want {
+			t.Errorf("canBeNullable(%q) = %v, want %v", chType, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"clickhouse-integration/internal/models"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrUploadTooLarge = errors.New("upload exceeds the size limit")
+)
+
+// Uploads are stored in UploadDir as <id>.data with a <id>.json metadata
+// record beside it. IDs are random hex, so resolving one can never reach
+// outside UploadDir.
+
+// SaveUpload streams src into a new upload owned by owner, computing its
+// size and SHA-256 on the way. Uploads larger than maxSize are discarded.
+func (s *FileService) SaveUpload(src io.Reader, name, owner string, maxSize int64) (*models.Upload, error) {
+	id, err := newFileID()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.UploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	path := s.uploadDataPath(id)
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+
+	hash := sha256.New()
+	// Read one byte past the limit to tell an exact fit from an overflow
+	size, err := io.Copy(io.MultiWriter(dst, hash), io.LimitReader(src, maxSize+1))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxSize {
+		err = fmt.Errorf("%w of %d bytes", ErrUploadTooLarge, maxSize)
+	}
+	if err != nil {
+		os.Remove(path)
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to save upload: %v", err)
+	}
+
+	upload := &models.Upload{
+		ID:        id,
+		Name:      filepath.Base(name),
+		Size:      size,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		Owner:     owner,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.writeUploadMeta(upload); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload returns the metadata of an upload. An empty owner skips the
+// ownership check; uploads of other owners are reported as not found so
+// their IDs cannot be probed.
+func (s *FileService) GetUpload(id, owner string) (*models.Upload, error) {
+	if !fileIDPattern.MatchString(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	upload, err := s.readUploadMeta(id)
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload metadata: %v", err)
+	}
+	if owner != "" && upload.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// UploadPath resolves an upload ID to the path of its data file.
+func (s *FileService) UploadPath(id, owner string) (string, error) {
+	if _, err := s.GetUpload(id, owner); err != nil {
+		return "", err
+	}
+	return s.uploadDataPath(id), nil
+}
+
+// DeleteUpload removes an upload and its metadata.
+func (s *FileService) DeleteUpload(id, owner string) error {
+	if _, err := s.GetUpload(id, owner); err != nil {
+		return err
+	}
+	if err := os.Remove(s.uploadDataPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	if err := os.Remove(s.uploadMetaPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload metadata: %v", err)
+	}
+	return nil
+}
+
+func (s *FileService) writeUploadMeta(upload *models.Upload) error {
+	data, err := json.MarshalIndent(upload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload metadata: %v", err)
+	}
+
+	path := s.uploadMetaPath(upload.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload metadata: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write upload metadata: %v", err)
+	}
+	return nil
+}
+
+func (s *FileService) readUploadMeta(id string) (*models.Upload, error) {
+	data, err := os.ReadFile(s.uploadMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var upload models.Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (s *FileService) uploadDataPath(id string) string {
+	return filepath.Join(s.UploadDir, id+".data")
+}
+
+func (s *FileService) uploadMetaPath(id string) string {
+	return filepath.Join(s.UploadDir, id+".json")
+}
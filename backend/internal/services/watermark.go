@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"clickhouse-integration/internal/models"
+)
+
+// WatermarkStore persists the last exported value of incremental exports
+// in a JSON file keyed by watermark key.
+type WatermarkStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewWatermarkStore(path string, logger *slog.Logger) *WatermarkStore {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Warn("failed to create watermark directory", "path", filepath.Dir(path), "error", err)
+	}
+	return &WatermarkStore{path: path}
+}
+
+// Get returns the stored watermark for key, or nil if none was saved yet.
+func (s *WatermarkStore) Get(key string) (*models.Watermark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if wm, ok := all[key]; ok {
+		return &wm, nil
+	}
+	return nil, nil
+}
+
+func (s *WatermarkStore) Save(wm models.Watermark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[wm.Key] = wm
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode watermarks: %v", err)
+	}
+
+	// Write to a temp file and rename so a crash never leaves a torn file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write watermarks: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write watermarks: %v", err)
+	}
+	return nil
+}
+
+func (s *WatermarkStore) load() (map[string]models.Watermark, error) {
+	all := make(map[string]models.Watermark)
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watermarks: %v", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to decode watermarks: %v", err)
+	}
+	return all, nil
+}
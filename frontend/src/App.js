@@ -82,7 +82,7 @@ function App() {
     port: '9000',
     database: 'default',
     user: 'default',
-    jwt_token: 'password',
+    password: 'password',
   });
   const [tables, setTables] = useState([]);
   const [selectedTable, setSelectedTable] = useState('');
@@ -92,6 +92,7 @@ function App() {
     delimiter: ',',
   });
   const [selectedFile, setSelectedFile] = useState(null);
+  const [uploadId, setUploadId] = useState('');
   const fileInputRef = useRef(null);
 
   const [columns, setColumns] = useState([]);
@@ -187,7 +188,7 @@ function App() {
         port: parseInt(connection.port),
         database: connection.database,
         user: connection.user,
-        jwtToken: connection.jwt_token
+        password: connection.password
       });
       if (response.data.success) {
         const tablesResponse = await axios.post(`${API_URL}/clickhouse/tables`, {
@@ -195,7 +196,7 @@ function App() {
           port: parseInt(connection.port),
           database: connection.database,
           user: connection.user,
-          jwtToken: connection.jwt_token
+          password: connection.password
         });
         if (tablesResponse.data.success) {
           setTables(tablesResponse.data.data);
@@ -217,7 +218,7 @@ function App() {
         port: parseInt(connection.port),
         database: connection.database,
         user: connection.user,
-        jwtToken: connection.jwt_token
+        password: connection.password
       });
       if (response.data.success) {
         setColumns(response.data.data);
@@ -244,8 +245,9 @@ function App() {
       });
 
       if (uploadResponse.data.success) {
-        const filePath = uploadResponse.data.data.filePath;
-        const columnsResponse = await axios.get(`${API_URL}/file/columns?filePath=${filePath}&delimiter=${fileConfig.delimiter}`);
+        const id = uploadResponse.data.data.id;
+        setUploadId(id);
+        const columnsResponse = await axios.get(`${API_URL}/file/columns?uploadId=${id}&delimiter=${fileConfig.delimiter}`);
         if (columnsResponse.data.success) {
           // Convert string columns to Column objects with inferred types
           const columnObjects = columnsResponse.data.data.map(col => ({
@@ -287,7 +289,7 @@ function App() {
             port: parseInt(connection.port),
             database: connection.database,
             user: connection.user,
-            jwtToken: connection.jwt_token
+            password: connection.password
           },
           table: selectedTable,
           columns: selectedColumns
@@ -297,12 +299,14 @@ function App() {
           setMessage({ type: 'warning', text: 'Please select a file first.' });
           return;
         }
-        response = await axios.get(`${API_URL}/file/preview?filePath=${selectedFile.name}&delimiter=${fileConfig.delimiter}&limit=100`);
+        response = await axios.get(`${API_URL}/file/preview?uploadId=${uploadId}&delimiter=${fileConfig.delimiter}&limit=100`);
       }
 
       if (response.data.success) {
+        // ClickHouse exports return { columns, rows }; file previews return rows
+        const data = response.data.data;
         setPreviewColumns(selectedColumns);
-        setPreviewData(response.data.data);
+        setPreviewData(Array.isArray(data) ? data : data.rows);
         setIsPreviewOpen(true);
       }
     } catch (error) {
@@ -330,7 +334,7 @@ function App() {
             port: parseInt(connection.port),
             database: connection.database,
             user: connection.user,
-            jwtToken: connection.jwt_token
+            password: connection.password
           },
           table: selectedTable,
           columns: selectedColumns
@@ -355,7 +359,7 @@ function App() {
           throw new Error('Failed to upload file');
         }
 
-        const filePath = uploadResponse.data.data.filePath;
+        const newUploadId = uploadResponse.data.data.id;
 
         // Then import the data
         response = await axios.post(`${API_URL}/clickhouse/import`, {
@@ -364,11 +368,11 @@ function App() {
             port: parseInt(connection.port),
             database: connection.database,
             user: connection.user,
-            jwtToken: connection.jwt_token
+            password: connection.password
           },
           table: selectedTable || selectedFile.name.split('.')[0],
           columns: selectedColumns,
-          filePath: filePath,
+          uploadId: newUploadId,
           delimiter: fileConfig.delimiter
         });
       }
@@ -438,7 +442,7 @@ function App() {
               <TextField fullWidth name="port" label="Port" value={connection.port} onChange={handleConnectionChange} sx={{ mb: 2 }}/>
               <TextField fullWidth name="database" label="Database" value={connection.database} onChange={handleConnectionChange} sx={{ mb: 2 }}/>
               <TextField fullWidth name="user" label="User" value={connection.user} onChange={handleConnectionChange} sx={{ mb: 2 }}/>
-              <TextField fullWidth name="jwt_token" label="Password/JWT Token" type="password" value={connection.jwt_token} onChange={handleConnectionChange} sx={{ mb: 2 }}/>
+              <TextField fullWidth name="password" label="Password" type="password" value={connection.password} onChange={handleConnectionChange} sx={{ mb: 2 }}/>
               <Button variant="contained" onClick={connectToClickHouse} disabled={loading} sx={{ mb: 2 }}>
                 Connect & List Tables
               </Button>
@@ -447,7 +451,7 @@ function App() {
                 <FormControl fullWidth sx={{ mb: 2 }}>
                   <InputLabel>Select Table</InputLabel>
                   <Select value={selectedTable} label="Select Table" onChange={handleTableChange}>
-                    {tables.map((table) => (<MenuItem key={table} value={table}>{table}</MenuItem>))}
+                    {tables.map((table) => (<MenuItem key={table.name} value={table.name}>{table.name} ({table.type})</MenuItem>))}
                   </Select>
                 </FormControl>
               )}